@@ -0,0 +1,114 @@
+package core
+
+import (
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"math/rand"
+	"net/http"
+	"time"
+)
+
+type accessLogConfig struct {
+	LogConfig     `mapstructure:",squash"`
+	Enable        bool          `json:"enable" mapstructure:"enable"`
+	SlowThreshold time.Duration `json:"slow_threshold" mapstructure:"slow_threshold"` //慢请求阈值，超过后以warn级别输出
+	SampleRate    *float64      `json:"sample_rate" mapstructure:"sample_rate"`       //成功请求的采样率 0-1，未配置时为1，0为不输出成功请求，错误及慢请求始终输出
+	SkipPaths     []string      `json:"skip_paths" mapstructure:"skip_paths"`         //不记录的路由，例如健康检查
+}
+
+func parseAccessLogConfig() accessLogConfig {
+	conf := accessLogConfig{}
+	if err := globalConfig.UnmarshalKey("log.access", &conf); err != nil {
+		panic("log.access 配置解析错误" + err.Error())
+	}
+	if conf.SampleRate == nil {
+		rate := 1.0
+		conf.SampleRate = &rate
+	}
+	if conf.SlowThreshold == 0 {
+		conf.SlowThreshold = time.Second
+	}
+	if conf.OutputFile {
+		if conf.Filename == "" {
+			conf.Filename = "./logs/access.log"
+		}
+		if conf.MaxAge == 0 {
+			conf.MaxAge = 7
+		}
+		if conf.MaxBackups == 0 {
+			conf.MaxBackups = 3
+		}
+		if conf.MaxSize == 0 {
+			conf.MaxSize = 10
+		}
+	}
+	return conf
+}
+
+// newAccessLogger 配置了独立输出时使用单独的日志文件，否则使用服务日志
+func newAccessLogger(conf accessLogConfig) *zap.Logger {
+	if !conf.OutputFile && !conf.OutputConsole {
+		return globalLog.Named("access")
+	}
+	core := zapcore.NewCore(
+		zapcore.NewJSONEncoder(newEncoderConfig()),
+		newLogWriter(conf.LogConfig),
+		zapcore.InfoLevel,
+	)
+	return zap.New(core, zap.Fields(zap.String("service", globalServiceName)))
+}
+
+// AccessLog 访问日志
+func AccessLog() HandlerFunc {
+	conf := parseAccessLogConfig()
+	logger := newAccessLogger(conf)
+	skip := map[string]bool{}
+	for _, item := range conf.SkipPaths {
+		skip[item] = true
+	}
+
+	return func(ctx *Context) {
+		if !conf.Enable {
+			return
+		}
+		start := time.Now()
+		w := ctx.Writer
+		ctx.Next()
+
+		if skip[ctx.Pattern] || skip[ctx.Path] {
+			return
+		}
+		latency := time.Since(start)
+		status := w.Status()
+		slow := latency >= conf.SlowThreshold
+		failure := status >= http.StatusBadRequest
+		if !slow && !failure && *conf.SampleRate < 1 && rand.Float64() >= *conf.SampleRate {
+			return
+		}
+
+		fields := []zap.Field{
+			zap.String(TraceID, ctx.TraceID),
+			zap.String("method", ctx.Method),
+			zap.String("route", ctx.Pattern),
+			zap.String("path", ctx.Path),
+			zap.String("query", ctx.Request.URL.RawQuery),
+			zap.Int("status", status),
+			zap.Int("bytes", w.Size()),
+			zap.Duration("latency", latency),
+			zap.String("ip", ctx.ClientIP()),
+			zap.String("user_agent", ctx.Request.UserAgent()),
+			zap.Bool("slow", slow),
+		}
+		if id := ctx.GetString(UserIDKey); id != "" {
+			fields = append(fields, zap.String(UserIDKey, id))
+		}
+		switch {
+		case status >= http.StatusInternalServerError:
+			logger.Error("access", fields...)
+		case slow || failure:
+			logger.Warn("access", fields...)
+		default:
+			logger.Info("access", fields...)
+		}
+	}
+}
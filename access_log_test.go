@@ -0,0 +1,78 @@
+package core
+
+import (
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// serveAccessLog 使用指定的配置处理请求，返回输出的访问日志
+func serveAccessLog(access H, n int, handler HandlerFunc) []observer.LoggedEntry {
+	v := viper.New()
+	v.Set("log.access", access)
+	core, logs := observer.New(zap.DebugLevel)
+	config, log := globalConfig, globalLog
+	globalConfig, globalLog = v, zap.New(core)
+	defer func() { globalConfig, globalLog = config, log }()
+
+	accessLog := AccessLog()
+	for i := 0; i < n; i++ {
+		ctx := newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/order/1?a=1", nil))
+		ctx.TraceID = "trace-1"
+		ctx.Pattern = "/order/:id"
+		ctx.handlers = []HandlerFunc{accessLog, handler}
+		ctx.Next()
+	}
+	return logs.FilterMessage("access").All()
+}
+
+func TestAccessLog(t *testing.T) {
+	entries := serveAccessLog(H{"enable": true, "slow_threshold": "20ms"}, 1, func(ctx *Context) {
+		time.Sleep(30 * time.Millisecond)
+		ctx.String(http.StatusNotFound, "not found")
+	})
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 access log, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if entries[0].Level != zapcore.WarnLevel || entries[0].LoggerName != "access" {
+		t.Errorf("unexpected level %s logger %s", entries[0].Level, entries[0].LoggerName)
+	}
+	if fields["status"] != int64(http.StatusNotFound) || fields["bytes"] != int64(len("not found")) ||
+		fields["route"] != "/order/:id" || fields["path"] != "/order/1" || fields["query"] != "a=1" ||
+		fields[TraceID] != "trace-1" || fields["slow"] != true {
+		t.Errorf("unexpected fields %v", fields)
+	}
+	if latency, _ := fields["latency"].(time.Duration); latency < 30*time.Millisecond {
+		t.Errorf("latency = %v", latency)
+	}
+}
+
+func TestAccessLogSample(t *testing.T) {
+	ok := func(ctx *Context) { ctx.String(http.StatusOK, "ok") }
+	fail := func(ctx *Context) { ctx.String(http.StatusInternalServerError, "fail") }
+	tests := []struct {
+		name    string
+		access  H
+		handler HandlerFunc
+		min     int
+		max     int
+	}{
+		{"default logs all", H{"enable": true}, ok, 100, 100},
+		{"zero logs no success", H{"enable": true, "sample_rate": 0}, ok, 0, 0},
+		{"zero keeps errors", H{"enable": true, "sample_rate": 0}, fail, 100, 100},
+		{"half", H{"enable": true, "sample_rate": 0.5}, ok, 20, 80},
+		{"skip path", H{"enable": true, "skip_paths": []string{"/order/:id"}}, fail, 0, 0},
+		{"disabled", H{}, fail, 0, 0},
+	}
+	for _, tt := range tests {
+		if n := len(serveAccessLog(tt.access, 100, tt.handler)); n < tt.min || n > tt.max {
+			t.Errorf("%s: got %d access logs", tt.name, n)
+		}
+	}
+}
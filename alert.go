@@ -0,0 +1,628 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+)
+
+// 告警类型
+const (
+	AlertPanic    = "panic"
+	AlertSlowSQL  = "slow_sql"
+	AlertSQLError = "sql_error"
+	AlertErrorLog = "error_log"
+	AlertBreaker  = "breaker"
+)
+
+type alertReceiver struct {
+	Name      string   `json:"name" mapstructure:"name"`
+	Type      string   `json:"type" mapstructure:"type"`             //dingtalk/feishu/wecom/webhook
+	Webhook   string   `json:"webhook" mapstructure:"webhook"`       //机器人或回调地址
+	Secret    string   `json:"secret" mapstructure:"secret"`         //钉钉及飞书的加签密钥
+	Kinds     []string `json:"kinds" mapstructure:"kinds"`           //接收的告警类型，为空时接收全部
+	AtMobiles []string `json:"at_mobiles" mapstructure:"at_mobiles"` //钉钉@的手机号
+	AtAll     bool     `json:"at_all" mapstructure:"at_all"`
+	RateLimit int      `json:"rate_limit" mapstructure:"rate_limit"` //每分钟最多发送的条数，机器人通常限制为20
+}
+
+type alertConfig struct {
+	Enable      bool              `json:"enable" mapstructure:"enable"`
+	Kinds       []string          `json:"kinds" mapstructure:"kinds"`               //启用的告警类型，为空时全部启用
+	DedupWindow time.Duration     `json:"dedup_window" mapstructure:"dedup_window"` //相同告警的去重时间
+	Timeout     time.Duration     `json:"timeout" mapstructure:"timeout"`           //发送超时时间
+	Templates   map[string]string `json:"templates" mapstructure:"templates"`       //按告警类型自定义消息模板，default 为默认模板
+	Receivers   []alertReceiver   `json:"receivers" mapstructure:"receivers"`
+
+	kinds     map[string]bool
+	templates map[string]*template.Template
+}
+
+const defaultAlertTemplate = `### {{.Title}}
+- 服务: {{.Service}}
+- 类型: {{.Kind}}
+- 时间: {{.Time.Format "2006-01-02 15:04:05"}}
+{{- if .TraceID}}
+- 链路: {{.TraceID}}
+{{- end}}
+{{- range $key, $val := .Fields}}
+- {{$key}}: {{$val}}
+{{- end}}
+{{- if .Suppressed}}
+- 期间重复告警: {{.Suppressed}} 次
+{{- end}}
+
+{{.Content}}`
+
+// defaultAlertTpl 默认模板为常量，在包初始化时解析
+var defaultAlertTpl = template.Must(template.New("default").Parse(defaultAlertTemplate))
+
+var alertConf atomic.Value
+
+func initAlertConfig(v *viper.Viper) {
+	conf, err := parseAlertConfig(v)
+	storeConfig("alert", &alertConf, conf, err)
+}
+
+func parseAlertConfig(v *viper.Viper) (*alertConfig, error) {
+	conf := alertConfig{}
+	if err := v.UnmarshalKey("alert", &conf); err != nil {
+		return nil, err
+	}
+	if conf.DedupWindow == 0 {
+		conf.DedupWindow = 5 * time.Minute
+	}
+	if conf.Timeout == 0 {
+		conf.Timeout = 5 * time.Second
+	}
+	conf.kinds = map[string]bool{}
+	for _, kind := range conf.Kinds {
+		conf.kinds[kind] = true
+	}
+	conf.templates = map[string]*template.Template{
+		"default": defaultAlertTpl,
+	}
+	for kind, text := range conf.Templates {
+		tpl, err := template.New(kind).Parse(text)
+		if err != nil {
+			return nil, err
+		}
+		conf.templates[kind] = tpl
+	}
+	for i := range conf.Receivers {
+		if conf.Receivers[i].Name == "" {
+			conf.Receivers[i].Name = conf.Receivers[i].Type + ":" + conf.Receivers[i].Webhook
+		}
+		if conf.Receivers[i].RateLimit == 0 {
+			conf.Receivers[i].RateLimit = 20
+		}
+	}
+	return &conf, nil
+}
+
+func loadAlertConfig() *alertConfig {
+	conf, _ := alertConf.Load().(*alertConfig)
+	if conf == nil {
+		return &alertConfig{}
+	}
+	return conf
+}
+
+// Alert 告警信息
+type Alert struct {
+	Kind       string                 `json:"kind"`
+	Key        string                 `json:"key"` //去重的依据，为空时使用标题
+	Title      string                 `json:"title"`
+	Content    string                 `json:"content"`
+	Service    string                 `json:"service"`
+	TraceID    string                 `json:"trace_id"`
+	Fields     map[string]interface{} `json:"fields"`
+	Time       time.Time              `json:"time"`
+	Suppressed int                    `json:"suppressed"` //去重期间被抑制的次数
+}
+
+// AlertSender 告警发送方式
+type AlertSender interface {
+	Send(ctx context.Context, alert *Alert, message string) error
+}
+
+// AlertSenderFactory 根据接收方配置创建发送方式
+type AlertSenderFactory func(receiver alertReceiver) AlertSender
+
+var (
+	alertSenders = map[string]AlertSenderFactory{
+		"dingtalk": func(r alertReceiver) AlertSender { return &dingtalkSender{r} },
+		"feishu":   func(r alertReceiver) AlertSender { return &feishuSender{r} },
+		"wecom":    func(r alertReceiver) AlertSender { return &wecomSender{r} },
+		"webhook":  func(r alertReceiver) AlertSender { return &webhookSender{r} },
+	}
+	alertSenderMutex sync.RWMutex
+)
+
+// RegisterAlertSender 注册自定义的告警发送方式，在接收方的 type 字段中引用
+func RegisterAlertSender(name string, factory AlertSenderFactory) {
+	alertSenderMutex.Lock()
+	defer alertSenderMutex.Unlock()
+	alertSenders[name] = factory
+}
+
+var alertClient = &http.Client{}
+
+// postJSON 发送json请求，并通过 check 校验响应内容
+func postJSON(ctx context.Context, webhook string, data interface{}, check func(body []byte) error) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := alertClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("alert webhook status %d: %s", resp.StatusCode, body)
+	}
+	if check != nil {
+		return check(body)
+	}
+	return nil
+}
+
+// checkCode 校验机器人接口返回的错误码
+func checkCode(fields ...string) func(body []byte) error {
+	return func(body []byte) error {
+		res := map[string]interface{}{}
+		if err := json.Unmarshal(body, &res); err != nil {
+			return err
+		}
+		for _, field := range fields {
+			if code, ok := res[field].(float64); ok && code != 0 {
+				return fmt.Errorf("alert webhook fail: %s", body)
+			}
+		}
+		return nil
+	}
+}
+
+// dingtalkSender 钉钉机器人
+type dingtalkSender struct {
+	receiver alertReceiver
+}
+
+// dingtalkSign 钉钉加签，签名放在请求地址中
+func dingtalkSign(webhook, secret string, now time.Time) (string, error) {
+	timestamp := strconv.FormatInt(now.UnixNano()/int64(time.Millisecond), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "\n" + secret))
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return "", err
+	}
+	query := u.Query()
+	query.Set("timestamp", timestamp)
+	query.Set("sign", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	u.RawQuery = query.Encode()
+	return u.String(), nil
+}
+
+func (s *dingtalkSender) Send(ctx context.Context, alert *Alert, message string) error {
+	webhook := s.receiver.Webhook
+	if s.receiver.Secret != "" {
+		var err error
+		if webhook, err = dingtalkSign(webhook, s.receiver.Secret, time.Now()); err != nil {
+			return err
+		}
+	}
+	at := H{"atMobiles": s.receiver.AtMobiles, "isAtAll": s.receiver.AtAll}
+	for _, mobile := range s.receiver.AtMobiles {
+		message += " @" + mobile
+	}
+	data := H{
+		"msgtype":  "markdown",
+		"markdown": H{"title": alert.Title, "text": message},
+		"at":       at,
+	}
+	return postJSON(ctx, webhook, data, checkCode("errcode"))
+}
+
+// feishuSender 飞书机器人
+type feishuSender struct {
+	receiver alertReceiver
+}
+
+// feishuSign 飞书加签，以 timestamp+"\n"+密钥 作为密钥对空串签名
+func feishuSign(secret string, timestamp int64) string {
+	mac := hmac.New(sha256.New, []byte(strconv.FormatInt(timestamp, 10)+"\n"+secret))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *feishuSender) Send(ctx context.Context, alert *Alert, message string) error {
+	data := H{
+		"msg_type": "text",
+		"content":  H{"text": message},
+	}
+	if s.receiver.Secret != "" {
+		timestamp := time.Now().Unix()
+		data["timestamp"] = strconv.FormatInt(timestamp, 10)
+		data["sign"] = feishuSign(s.receiver.Secret, timestamp)
+	}
+	return postJSON(ctx, s.receiver.Webhook, data, checkCode("code", "StatusCode"))
+}
+
+// wecomSender 企业微信机器人
+type wecomSender struct {
+	receiver alertReceiver
+}
+
+func (s *wecomSender) Send(ctx context.Context, alert *Alert, message string) error {
+	data := H{
+		"msgtype":  "markdown",
+		"markdown": H{"content": message},
+	}
+	return postJSON(ctx, s.receiver.Webhook, data, checkCode("errcode"))
+}
+
+// webhookSender 通用回调，发送告警信息及渲染后的消息
+type webhookSender struct {
+	receiver alertReceiver
+}
+
+func (s *webhookSender) Send(ctx context.Context, alert *Alert, message string) error {
+	data := struct {
+		*Alert
+		Message string `json:"message"`
+	}{alert, message}
+	return postJSON(ctx, s.receiver.Webhook, data, nil)
+}
+
+// alertDispatcher 异步发送告警，负责去重及按接收方限流
+type alertDispatcher struct {
+	queue   chan *Alert
+	mu      sync.Mutex
+	dedup   map[string]*alertDedup
+	windows map[string]*alertWindow
+}
+
+type alertDedup struct {
+	sentAt     time.Time
+	suppressed int
+}
+
+type alertWindow struct {
+	start time.Time
+	count int
+}
+
+var (
+	dispatcher     = &alertDispatcher{queue: make(chan *Alert, 1000), dedup: map[string]*alertDedup{}, windows: map[string]*alertWindow{}}
+	dispatcherOnce sync.Once
+)
+
+// alertLog 告警模块自身的日志，不会再次触发告警
+func alertLog() *zap.Logger {
+	if globalLog == nil {
+		return zap.NewNop()
+	}
+	return globalLog.Named("alert")
+}
+
+// SendAlert 发送告警，告警在后台异步发送，队列已满时丢弃
+func SendAlert(alert *Alert) {
+	conf := loadAlertConfig()
+	if !conf.Enable || len(conf.Receivers) == 0 || (len(conf.kinds) != 0 && !conf.kinds[alert.Kind]) {
+		return
+	}
+	if alert.Service == "" {
+		alert.Service = globalServiceName
+	}
+	if alert.Time.IsZero() {
+		alert.Time = time.Now()
+	}
+	dispatcherOnce.Do(func() {
+		go dispatcher.run()
+	})
+	select {
+	case dispatcher.queue <- alert:
+	default:
+		alertLog().Warn("alert queue is full", zap.String("title", alert.Title))
+	}
+}
+
+func (d *alertDispatcher) run() {
+	for alert := range d.queue {
+		d.dispatch(alert)
+	}
+}
+
+// deduplicate 去重时间内相同的告警只发送一次，再次发送时带上被抑制的次数
+func (d *alertDispatcher) deduplicate(alert *Alert, window time.Duration) bool {
+	key := alert.Kind + "|" + alert.Key
+	if alert.Key == "" {
+		key += alert.Title
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	item, ok := d.dedup[key]
+	if ok && now.Sub(item.sentAt) < window {
+		item.suppressed++
+		return false
+	}
+	if ok {
+		alert.Suppressed = item.suppressed
+	}
+	// 过期的记录全部清理，持续产生不同的告警时不会无限增长
+	for name, item := range d.dedup {
+		if now.Sub(item.sentAt) >= window {
+			delete(d.dedup, name)
+		}
+	}
+	d.dedup[key] = &alertDedup{sentAt: now}
+	return true
+}
+
+// allow 接收方每分钟的发送限制
+func (d *alertDispatcher) allow(receiver alertReceiver) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	w, ok := d.windows[receiver.Name]
+	if !ok || now.Sub(w.start) >= time.Minute {
+		w = &alertWindow{start: now}
+		d.windows[receiver.Name] = w
+	}
+	if w.count >= receiver.RateLimit {
+		return false
+	}
+	w.count++
+	return true
+}
+
+func renderAlert(conf *alertConfig, alert *Alert) string {
+	tpl, ok := conf.templates[alert.Kind]
+	if !ok {
+		tpl = conf.templates["default"]
+	}
+	if tpl == nil {
+		return alert.Title + "\n" + alert.Content
+	}
+	var buf bytes.Buffer
+	if err := tpl.Execute(&buf, alert); err != nil {
+		return alert.Title + "\n" + alert.Content
+	}
+	return buf.String()
+}
+
+func (d *alertDispatcher) dispatch(alert *Alert) {
+	conf := loadAlertConfig()
+	if !d.deduplicate(alert, conf.DedupWindow) {
+		return
+	}
+	message := renderAlert(conf, alert)
+	for _, receiver := range conf.Receivers {
+		if len(receiver.Kinds) != 0 && !containsString(receiver.Kinds, alert.Kind) {
+			continue
+		}
+		if !d.allow(receiver) {
+			alertLog().Warn("alert is rate limited", zap.String("receiver", receiver.Name), zap.String("title", alert.Title))
+			continue
+		}
+		alertSenderMutex.RLock()
+		factory, ok := alertSenders[receiver.Type]
+		alertSenderMutex.RUnlock()
+		if !ok {
+			alertLog().Warn("alert sender not found", zap.String("type", receiver.Type))
+			continue
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), conf.Timeout)
+		err := factory(receiver).Send(ctx, alert, message)
+		cancel()
+		if err != nil {
+			alertLog().Warn("alert send fail", zap.String("receiver", receiver.Name), zap.Error(err))
+		}
+	}
+}
+
+func containsString(list []string, val string) bool {
+	for _, item := range list {
+		if item == val {
+			return true
+		}
+	}
+	return false
+}
+
+// alertCore 将error及以上级别的日志作为告警发送
+type alertCore struct {
+	zapcore.LevelEnabler
+	fields []zapcore.Field
+}
+
+// 不触发告警的日志，panic已经通过回调告警，访问日志及告警模块自身的日志不告警
+var alertIgnoreLoggers = map[string]bool{"panic": true, "access": true, "alert": true}
+
+func newAlertCore() zapcore.Core {
+	return &alertCore{LevelEnabler: zapcore.ErrorLevel}
+}
+
+func (c *alertCore) With(fields []zapcore.Field) zapcore.Core {
+	return &alertCore{LevelEnabler: c.LevelEnabler, fields: append(c.fields[:len(c.fields):len(c.fields)], fields...)}
+}
+
+func (c *alertCore) Check(entry zapcore.Entry, checked *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	// 按名字的最后一级判断，兼容 Named 嵌套后的名字，例如 access.panic
+	name := entry.LoggerName
+	if index := strings.LastIndexByte(name, '.'); index >= 0 {
+		name = name[index+1:]
+	}
+	if c.Enabled(entry.Level) && !alertIgnoreLoggers[name] {
+		return checked.AddCore(entry, c)
+	}
+	return checked
+}
+
+func (c *alertCore) Write(entry zapcore.Entry, fields []zapcore.Field) error {
+	conf := loadAlertConfig()
+	if !conf.Enable || (len(conf.kinds) != 0 && !conf.kinds[AlertErrorLog]) {
+		return nil
+	}
+	enc := zapcore.NewMapObjectEncoder()
+	for _, field := range append(c.fields[:len(c.fields):len(c.fields)], fields...) {
+		field.AddTo(enc)
+	}
+	// 告警发送到第三方，日志内容需要脱敏
+	message := MaskString(entry.Message)
+	alert := &Alert{
+		Kind:   AlertErrorLog,
+		Key:    message + "|" + entry.Caller.TrimmedPath(),
+		Title:  "错误日志: " + message,
+		Fields: map[string]interface{}{},
+		Time:   entry.Time,
+	}
+	for key, val := range enc.Fields {
+		if key == TraceID {
+			alert.TraceID = fmt.Sprintf("%v", val)
+			continue
+		}
+		if key == "service" {
+			continue
+		}
+		alert.Fields[key] = maskAlertField(val)
+	}
+	if entry.Caller.Defined {
+		alert.Fields["caller"] = entry.Caller.TrimmedPath()
+	}
+	if entry.Stack != "" {
+		alert.Content = entry.Stack
+	}
+	SendAlert(alert)
+	return nil
+}
+
+// maskAlertField 对日志字段脱敏，数字及布尔值保持原样，其余转为字符串后脱敏
+func maskAlertField(val interface{}) interface{} {
+	switch v := val.(type) {
+	case string:
+		return MaskString(v)
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, float32, float64, time.Duration:
+		return v
+	case nil:
+		return nil
+	}
+	data, err := json.Marshal(val)
+	if err != nil {
+		return MaskString(fmt.Sprintf("%v", val))
+	}
+	return MaskBody(data)
+}
+
+func (c *alertCore) Sync() error {
+	return nil
+}
+
+// alertPanic panic告警，recovery已经对告警进行了限流
+func alertPanic(info *PanicInfo) {
+	stack := info.Stack
+	if len(stack) > 10 {
+		stack = stack[:10]
+	}
+	key := info.Message
+	if len(stack) != 0 {
+		key += "|" + stack[0]
+	}
+	fields := map[string]interface{}{
+		"method": info.Method,
+		"path":   info.Path,
+		"route":  info.Route,
+		"ip":     info.ClientIP,
+	}
+	if info.UserID != "" {
+		fields["user_id"] = info.UserID
+	}
+	if info.Suppressed != 0 {
+		fields["suppressed"] = info.Suppressed
+	}
+	SendAlert(&Alert{
+		Kind:    AlertPanic,
+		Key:     key,
+		Title:   "服务异常: " + info.Message,
+		Content: "```\n" + strings.Join(stack, "\n") + "\n```",
+		TraceID: info.TraceID,
+		Fields:  fields,
+		Time:    info.Time,
+	})
+}
+
+// alertBreaker 熔断器打开时告警
+func alertBreaker(name, from, to string) {
+	if to != BreakerOpen {
+		return
+	}
+	SendAlert(&Alert{
+		Kind:   AlertBreaker,
+		Key:    name,
+		Title:  "熔断器打开: " + name,
+		Fields: map[string]interface{}{"from": from, "to": to},
+	})
+}
+
+// sqlLiteral SQL中的数字及字符串常量，去重及发送时替换为?，避免业务数据发送到第三方
+var sqlLiteral = regexp.MustCompile(`'(?:[^'\\]|\\.)*'|\b\d+(?:\.\d+)?\b`)
+
+// alertSQL 慢查询及错误SQL告警
+func alertSQL(ctx context.Context, kind, sql string, rows int64, cost float64, err error) {
+	id, _ := ctx.Value(TraceID).(string)
+	title := "慢查询"
+	fields := map[string]interface{}{"rows": rows, "time_ms": cost}
+	if err != nil {
+		// 错误信息中可能包含字段的值，例如 Duplicate entry
+		message := MaskString(err.Error())
+		title = "SQL错误: " + message
+		fields["error"] = message
+	}
+	sql = sqlLiteral.ReplaceAllString(sql, "?")
+	SendAlert(&Alert{
+		Kind:    kind,
+		Key:     sql,
+		Title:   title,
+		Content: "```sql\n" + sql + "\n```",
+		TraceID: id,
+		Fields:  fields,
+	})
+}
+
+var alertOnce sync.Once
+
+// initAlert 注册panic及熔断器的告警回调
+func initAlert() {
+	alertOnce.Do(func() {
+		OnPanic(alertPanic)
+		OnBreakerChange(alertBreaker)
+	})
+}
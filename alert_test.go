@@ -0,0 +1,188 @@
+package core
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestAlert(t *testing.T) {
+	received := make(chan map[string]interface{}, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		data := map[string]interface{}{}
+		_ = json.Unmarshal(body, &data)
+		data["path"] = r.URL.Path
+		data["query_sign"] = r.URL.Query().Get("sign")
+		received <- data
+		_, _ = w.Write([]byte(`{"errcode":0}`))
+	}))
+	defer srv.Close()
+
+	v := viper.New()
+	v.Set("alert", map[string]interface{}{
+		"enable":       true,
+		"dedup_window": "1m",
+		"receivers": []map[string]interface{}{
+			{"type": "dingtalk", "webhook": srv.URL + "/dingtalk", "secret": "secret"},
+			{"type": "feishu", "webhook": srv.URL + "/feishu", "secret": "secret", "kinds": []string{AlertBreaker}},
+		},
+	})
+	initAlertConfig(v)
+	defer alertConf.Store(&alertConfig{})
+
+	d := &alertDispatcher{dedup: map[string]*alertDedup{}, windows: map[string]*alertWindow{}}
+	alert := &Alert{Kind: AlertSlowSQL, Key: "select 1", Title: "慢查询", Time: time.Now()}
+	d.dispatch(alert)
+	d.dispatch(&Alert{Kind: AlertSlowSQL, Key: "select 1", Title: "慢查询", Time: time.Now()})
+	if len(received) != 1 {
+		t.Fatalf("duplicate alert should be suppressed, got %d", len(received))
+	}
+	data := <-received
+	if data["path"] != "/dingtalk" || data["msgtype"] != "markdown" || data["query_sign"] == "" {
+		t.Fatalf("unexpected dingtalk payload: %v", data)
+	}
+
+	d.dispatch(&Alert{Kind: AlertBreaker, Key: "order", Title: "熔断器打开: order", Time: time.Now()})
+	if len(received) != 2 {
+		t.Fatalf("breaker alert should be sent to all receivers, got %d", len(received))
+	}
+	<-received
+	data = <-received
+	if data["path"] != "/feishu" || data["msg_type"] != "text" || data["timestamp"] == nil ||
+		data["sign"] != feishuSign("secret", parseTimestamp(data["timestamp"])) {
+		t.Fatalf("unexpected feishu payload: %v", data)
+	}
+}
+
+func parseTimestamp(val interface{}) int64 {
+	s, _ := val.(string)
+	ts, _ := strconv.ParseInt(s, 10, 64)
+	return ts
+}
+
+type captureSender struct {
+	alerts chan *Alert
+}
+
+func (s *captureSender) Send(_ context.Context, alert *Alert, _ string) error {
+	s.alerts <- alert
+	return nil
+}
+
+func TestAlertMask(t *testing.T) {
+	v := viper.New()
+	v.Set("mask", map[string]interface{}{"builtins": []string{"phone"}})
+	v.Set("alert", map[string]interface{}{
+		"enable":    true,
+		"receivers": []map[string]interface{}{{"type": "capture", "name": "capture"}},
+	})
+	initMaskConfig(v)
+	initAlertConfig(v)
+	defer func() {
+		maskConf.Store(&maskConfig{})
+		alertConf.Store(&alertConfig{})
+	}()
+	sender := &captureSender{alerts: make(chan *Alert, 10)}
+	RegisterAlertSender("capture", func(alertReceiver) AlertSender { return sender })
+
+	receive := func() *Alert {
+		select {
+		case alert := <-sender.alerts:
+			return alert
+		case <-time.After(time.Second):
+			t.Fatal("alert not sent")
+		}
+		return nil
+	}
+
+	alertSQL(context.Background(), AlertSQLError, "select * from user where phone = '13912345678' and id = 5", 0, 1.5,
+		errors.New("Duplicate entry '13912345678' for key 'phone'"))
+	alert := receive()
+	if strings.Contains(alert.Content, "13912345678") || !strings.Contains(alert.Content, "phone = ? and id = ?") {
+		t.Errorf("sql content should not contain literals: %s", alert.Content)
+	}
+	if strings.Contains(alert.Title, "13912345678") || alert.Fields["error"] != "Duplicate entry '139****5678' for key 'phone'" {
+		t.Errorf("sql error should be masked: %s %v", alert.Title, alert.Fields["error"])
+	}
+
+	logger := zap.New(newAlertCore())
+	logger.Error("notify 13912345678 fail", zap.String("phone", "13912345678"), zap.Int("retry", 3),
+		zap.Any("user", map[string]string{"mobile": "13912345678"}))
+	alert = receive()
+	if alert.Title != "错误日志: notify 139****5678 fail" {
+		t.Errorf("error log title should be masked: %s", alert.Title)
+	}
+	if alert.Fields["phone"] != "139****5678" || alert.Fields["retry"] != int64(3) ||
+		strings.Contains(fmt.Sprint(alert.Fields["user"]), "13912345678") {
+		t.Errorf("error log fields should be masked: %v", alert.Fields)
+	}
+}
+
+func TestAlertDeduplicate(t *testing.T) {
+	d := &alertDispatcher{dedup: map[string]*alertDedup{}, windows: map[string]*alertWindow{}}
+	window := 50 * time.Millisecond
+
+	tests := []struct {
+		name       string
+		key        string
+		sleep      time.Duration
+		send       bool
+		suppressed int
+		entries    int
+	}{
+		{"first", "a", 0, true, 0, 1},
+		{"duplicate", "a", 0, false, 0, 1},
+		{"duplicate again", "a", 0, false, 0, 1},
+		{"other", "b", 0, true, 0, 2},
+		{"after window", "a", 60 * time.Millisecond, true, 2, 1},
+		{"expire old entries", "c", 60 * time.Millisecond, true, 0, 1},
+	}
+	for _, tt := range tests {
+		time.Sleep(tt.sleep)
+		alert := &Alert{Kind: AlertErrorLog, Key: tt.key}
+		if got := d.deduplicate(alert, window); got != tt.send {
+			t.Errorf("%s: send = %v, want %v", tt.name, got, tt.send)
+		}
+		if alert.Suppressed != tt.suppressed {
+			t.Errorf("%s: suppressed = %d, want %d", tt.name, alert.Suppressed, tt.suppressed)
+		}
+		if len(d.dedup) != tt.entries {
+			t.Errorf("%s: %d dedup entries, want %d", tt.name, len(d.dedup), tt.entries)
+		}
+	}
+}
+
+func TestAlertIgnoreLoggers(t *testing.T) {
+	core := newAlertCore()
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"", true},
+		{"order", true},
+		{"panic", false},
+		{"x.alert", false},
+		{"request.panic", false},
+		{"access", false},
+		{"panic.order", true},
+	}
+	for _, tt := range tests {
+		entry := zapcore.Entry{Level: zapcore.ErrorLevel, LoggerName: tt.name}
+		if got := core.Check(entry, nil) != nil; got != tt.want {
+			t.Errorf("logger %q: alert = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
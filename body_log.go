@@ -0,0 +1,181 @@
+package core
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+)
+
+type bodyLogConfig struct {
+	Inbound      bool     `json:"inbound" mapstructure:"inbound"`             //是否记录请求及响应内容
+	Outbound     bool     `json:"outbound" mapstructure:"outbound"`           //HttpTool 日志是否记录请求及响应内容
+	MaxSize      int      `json:"max_size" mapstructure:"max_size"`           //记录的最大长度，超出部分截断
+	ContentTypes []string `json:"content_types" mapstructure:"content_types"` //需要记录的内容类型，按前缀匹配
+}
+
+var bodyLogConf atomic.Value
+
+func initBodyLogConfig(v *viper.Viper) {
+	conf, err := parseBodyLogConfig(v)
+	storeConfig("body_log", &bodyLogConf, conf, err)
+}
+
+func parseBodyLogConfig(v *viper.Viper) (bodyLogConfig, error) {
+	conf := bodyLogConfig{}
+	if err := v.UnmarshalKey("body_log", &conf); err != nil {
+		return bodyLogConfig{}, err
+	}
+	if conf.MaxSize == 0 {
+		conf.MaxSize = 4096
+	}
+	if len(conf.ContentTypes) == 0 {
+		conf.ContentTypes = []string{"application/json", "application/x-www-form-urlencoded", "application/xml", "text/"}
+	}
+	return conf, nil
+}
+
+func loadBodyLogConfig() bodyLogConfig {
+	conf, _ := bodyLogConf.Load().(bodyLogConfig)
+	return conf
+}
+
+// loggable 判断内容类型是否需要记录，未设置类型时按需要记录处理
+func (c bodyLogConfig) loggable(header http.Header) bool {
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		return true
+	}
+	for _, item := range c.ContentTypes {
+		if strings.HasPrefix(contentType, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// format 截断并脱敏
+func (c bodyLogConfig) format(data []byte) string {
+	if len(data) > c.MaxSize {
+		return MaskBody(data[:c.MaxSize]) + "...(truncated)"
+	}
+	return MaskBody(data)
+}
+
+// maskValues 对表单及查询参数按字段名脱敏
+func maskValues(values url.Values) url.Values {
+	conf := loadMaskConfig()
+	res := make(url.Values, len(values))
+	for key, val := range values {
+		if conf.fields[strings.ToLower(key)] {
+			res[key] = []string{conf.Replacement}
+			continue
+		}
+		list := make([]string, len(val))
+		for i, item := range val {
+			list[i] = conf.maskString(item)
+		}
+		res[key] = list
+	}
+	return res
+}
+
+// outboundBody 将 HttpTool 的请求体转换为日志内容
+func outboundBody(conf bodyLogConfig, header http.Header, body interface{}) string {
+	if body == nil || !conf.loggable(header) {
+		return ""
+	}
+	switch data := body.(type) {
+	case []byte:
+		return conf.format(data)
+	case string:
+		return conf.format([]byte(data))
+	case io.Reader:
+		return "[stream]"
+	default:
+		res, err := json.Marshal(data)
+		if err != nil {
+			return ""
+		}
+		return conf.format(res)
+	}
+}
+
+// bodyCaptureWriter 在输出响应的同时保留响应体的前 limit 个字节
+type bodyCaptureWriter struct {
+	ResponseWriter
+	limit int
+	buf   []byte
+}
+
+func (w *bodyCaptureWriter) Write(b []byte) (int, error) {
+	if rest := w.limit + 1 - len(w.buf); rest > 0 {
+		if len(b) < rest {
+			rest = len(b)
+		}
+		w.buf = append(w.buf, b[:rest]...)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyCaptureWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// captureRequestBody 读取请求体的前 limit+1 个字节，剩余部分保持流式读取
+func captureRequestBody(r *http.Request, limit int) []byte {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+	buf, _ := ioutil.ReadAll(io.LimitReader(r.Body, int64(limit+1)))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(buf), r.Body), r.Body}
+	return buf
+}
+
+// BodyLog 记录请求及响应内容，内容按 mask 配置脱敏
+func BodyLog() HandlerFunc {
+	return func(ctx *Context) {
+		conf := loadBodyLogConfig()
+		if !conf.Inbound {
+			return
+		}
+		var request []byte
+		if conf.loggable(ctx.Request.Header) {
+			request = captureRequestBody(ctx.Request, conf.MaxSize)
+		}
+		w := ctx.Writer
+		cw := &bodyCaptureWriter{ResponseWriter: w, limit: conf.MaxSize}
+		ctx.Writer = cw
+		defer func() {
+			ctx.Writer = w
+		}()
+		ctx.Next()
+
+		fields := []zap.Field{
+			zap.String("method", ctx.Method),
+			zap.String("path", ctx.Path),
+			zap.Any("query", maskValues(ctx.Request.URL.Query())),
+			zap.Any("header", MaskHeader(ctx.Request.Header)),
+			zap.String("body", conf.format(request)),
+			zap.Int("status", w.Status()),
+		}
+		if conf.loggable(w.Header()) {
+			fields = append(fields, zap.String("response", conf.format(cw.buf)))
+		}
+		ctx.Log.Info("http body", fields...)
+	}
+}
@@ -0,0 +1,339 @@
+package core
+
+import (
+	"errors"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrBreakerOpen 熔断器处于打开状态，请求被拒绝
+var ErrBreakerOpen = errors.New("circuit breaker is open")
+
+type breakerConfig struct {
+	Enable           bool          `json:"enable" mapstructure:"enable"`                         //是否启用入口熔断
+	Outbound         bool          `json:"outbound" mapstructure:"outbound"`                     //是否对http_tool的下游请求按host熔断
+	Window           time.Duration `json:"window" mapstructure:"window"`                         //统计窗口时长
+	Buckets          int           `json:"buckets" mapstructure:"buckets"`                       //统计窗口的桶数量
+	MinRequests      int64         `json:"min_requests" mapstructure:"min_requests"`             //窗口内最少请求数，达到后才进行熔断判定
+	ErrorRatio       float64       `json:"error_ratio" mapstructure:"error_ratio"`               //错误率阈值
+	SlowCallTime     time.Duration `json:"slow_call_time" mapstructure:"slow_call_time"`         //慢调用时长
+	SlowCallRatio    float64       `json:"slow_call_ratio" mapstructure:"slow_call_ratio"`       //慢调用比例阈值
+	OpenTime         time.Duration `json:"open_time" mapstructure:"open_time"`                   //熔断持续时长，结束后进入半开状态
+	HalfOpenRequests int           `json:"half_open_requests" mapstructure:"half_open_requests"` //半开状态下允许的探测请求数
+}
+
+var breakerConf atomic.Value
+
+func initBreakerConfig(v *viper.Viper) {
+	conf, err := parseBreakerConfig(v)
+	storeConfig("breaker", &breakerConf, conf, err)
+}
+
+func parseBreakerConfig(v *viper.Viper) (breakerConfig, error) {
+	conf := breakerConfig{}
+	if err := v.UnmarshalKey("breaker", &conf); err != nil {
+		return breakerConfig{}, err
+	}
+	if conf.Window == 0 {
+		conf.Window = 10 * time.Second
+	}
+	if conf.Buckets == 0 {
+		conf.Buckets = 10
+	}
+	if conf.MinRequests == 0 {
+		conf.MinRequests = 20
+	}
+	if conf.ErrorRatio == 0 {
+		conf.ErrorRatio = 0.5
+	}
+	if conf.SlowCallTime == 0 {
+		conf.SlowCallTime = 3 * time.Second
+	}
+	if conf.SlowCallRatio == 0 {
+		conf.SlowCallRatio = 1
+	}
+	if conf.OpenTime == 0 {
+		conf.OpenTime = 5 * time.Second
+	}
+	if conf.HalfOpenRequests == 0 {
+		conf.HalfOpenRequests = 3
+	}
+	return conf, nil
+}
+
+func loadBreakerConfig() breakerConfig {
+	conf, _ := breakerConf.Load().(breakerConfig)
+	return conf
+}
+
+// 熔断器状态
+const (
+	BreakerClosed   = "closed"
+	BreakerOpen     = "open"
+	BreakerHalfOpen = "half-open"
+)
+
+// BreakerStat 熔断器的当前状态及统计窗口内的数据
+type BreakerStat struct {
+	Name    string `json:"name"`
+	State   string `json:"state"`
+	Total   int64  `json:"total"`
+	Failure int64  `json:"failure"`
+	Slow    int64  `json:"slow"`
+}
+
+// BreakerHook 熔断器状态变更回调
+type BreakerHook func(name, from, to string)
+
+type breakerBucket struct {
+	total   int64
+	failure int64
+	slow    int64
+}
+
+type circuitBreaker struct {
+	name     string
+	mu       sync.Mutex
+	state    string
+	buckets  []breakerBucket
+	index    int       // 当前桶的下标
+	start    time.Time // 当前桶的开始时间
+	openedAt time.Time
+	probes   int // 半开状态下已放行的探测请求
+	passed   int // 半开状态下成功的探测请求
+}
+
+var (
+	breakers     = map[string]*circuitBreaker{}
+	breakerMutex sync.RWMutex
+	breakerHooks []BreakerHook
+)
+
+// OnBreakerChange 注册熔断器状态变更回调，可用于告警或指标上报
+func OnBreakerChange(hook BreakerHook) {
+	breakerMutex.Lock()
+	defer breakerMutex.Unlock()
+	breakerHooks = append(breakerHooks, hook)
+}
+
+// BreakerStats 获取所有熔断器的状态，用于指标上报
+func BreakerStats() []BreakerStat {
+	breakerMutex.RLock()
+	list := make([]*circuitBreaker, 0, len(breakers))
+	for _, item := range breakers {
+		list = append(list, item)
+	}
+	breakerMutex.RUnlock()
+
+	stats := make([]BreakerStat, 0, len(list))
+	for _, item := range list {
+		stats = append(stats, item.stat())
+	}
+	return stats
+}
+
+func getBreaker(name string) *circuitBreaker {
+	breakerMutex.RLock()
+	b, ok := breakers[name]
+	breakerMutex.RUnlock()
+	if ok {
+		return b
+	}
+
+	breakerMutex.Lock()
+	defer breakerMutex.Unlock()
+	if b, ok = breakers[name]; ok {
+		return b
+	}
+	b = &circuitBreaker{name: name, state: BreakerClosed}
+	breakers[name] = b
+	return b
+}
+
+// allow 判断请求是否可以通过
+func (b *circuitBreaker) allow() error {
+	conf := loadBreakerConfig()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < conf.OpenTime {
+			return ErrBreakerOpen
+		}
+		b.setState(BreakerHalfOpen)
+		fallthrough
+	case BreakerHalfOpen:
+		if b.probes >= conf.HalfOpenRequests {
+			return ErrBreakerOpen
+		}
+		b.probes++
+	}
+	return nil
+}
+
+// done 上报请求结果
+func (b *circuitBreaker) done(failure bool, elapsed time.Duration) {
+	conf := loadBreakerConfig()
+	slow := elapsed >= conf.SlowCallTime
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		return
+	case BreakerHalfOpen:
+		if failure || slow {
+			b.setState(BreakerOpen)
+			return
+		}
+		b.passed++
+		if b.passed >= conf.HalfOpenRequests {
+			b.setState(BreakerClosed)
+		}
+		return
+	}
+
+	bucket := b.current(conf)
+	bucket.total++
+	if failure {
+		bucket.failure++
+	}
+	if slow {
+		bucket.slow++
+	}
+
+	total, failures, slows := b.sum()
+	if total < conf.MinRequests {
+		return
+	}
+	if float64(failures)/float64(total) >= conf.ErrorRatio || float64(slows)/float64(total) >= conf.SlowCallRatio {
+		b.setState(BreakerOpen)
+	}
+}
+
+// current 滑动窗口，淘汰过期的桶并返回当前的桶
+func (b *circuitBreaker) current(conf breakerConfig) *breakerBucket {
+	now := time.Now()
+	if len(b.buckets) != conf.Buckets {
+		b.buckets = make([]breakerBucket, conf.Buckets)
+		b.index = 0
+		b.start = now
+	}
+
+	span := conf.Window / time.Duration(conf.Buckets)
+	if span <= 0 {
+		span = time.Millisecond
+	}
+	offset := int(now.Sub(b.start) / span)
+	if offset >= len(b.buckets) {
+		offset = len(b.buckets)
+	}
+	for i := 0; i < offset; i++ {
+		b.index = (b.index + 1) % len(b.buckets)
+		b.buckets[b.index] = breakerBucket{}
+	}
+	if offset > 0 {
+		b.start = now
+	}
+	return &b.buckets[b.index]
+}
+
+func (b *circuitBreaker) sum() (total, failure, slow int64) {
+	for _, item := range b.buckets {
+		total += item.total
+		failure += item.failure
+		slow += item.slow
+	}
+	return
+}
+
+// setState 切换状态，需在持有锁的情况下调用
+func (b *circuitBreaker) setState(state string) {
+	from := b.state
+	if from == state {
+		return
+	}
+	b.state = state
+	b.probes = 0
+	b.passed = 0
+	switch state {
+	case BreakerOpen:
+		b.openedAt = time.Now()
+	case BreakerClosed:
+		b.buckets = nil
+	}
+
+	if globalLog != nil {
+		globalLog.Warn("circuit breaker state change",
+			zap.String("name", b.name),
+			zap.String("from", from),
+			zap.String("to", state),
+		)
+	}
+	breakerMutex.RLock()
+	hooks := breakerHooks
+	breakerMutex.RUnlock()
+	for _, hook := range hooks {
+		go hook(b.name, from, state)
+	}
+}
+
+func (b *circuitBreaker) stat() BreakerStat {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	total, failure, slow := b.sum()
+	return BreakerStat{Name: b.name, State: b.state, Total: total, Failure: failure, Slow: slow}
+}
+
+// Breaker 入口熔断，按路由统计错误率及慢调用比例，可用于全局、分组或单个路由
+func Breaker() HandlerFunc {
+	return func(ctx *Context) {
+		if !loadBreakerConfig().Enable || ctx.Pattern == "" {
+			return
+		}
+		b := getBreaker(ctx.Method + " " + ctx.Pattern)
+		if err := b.allow(); err != nil {
+			ctx.Fail(http.StatusServiceUnavailable, "服务熔断中，请稍后再试")
+			return
+		}
+
+		start := time.Now()
+		failure := true // 处理过程中发生panic时按失败处理
+		defer func() {
+			b.done(failure, time.Since(start))
+		}()
+		ctx.Next()
+		failure = ctx.Writer.Status() >= http.StatusInternalServerError
+	}
+}
+
+// breakerTransport 出口熔断，按下游host统计
+type breakerTransport struct {
+	next http.RoundTripper
+}
+
+func newBreakerTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &breakerTransport{next: next}
+}
+
+func (t *breakerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !loadBreakerConfig().Outbound {
+		return t.next.RoundTrip(req)
+	}
+	b := getBreaker("http " + req.URL.Host)
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	b.done(err != nil || resp.StatusCode >= http.StatusInternalServerError, time.Since(start))
+	return resp, err
+}
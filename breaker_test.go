@@ -0,0 +1,45 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	breakerConf.Store(breakerConfig{
+		Window:           time.Second,
+		Buckets:          10,
+		MinRequests:      4,
+		ErrorRatio:       0.5,
+		SlowCallTime:     time.Second,
+		SlowCallRatio:    1,
+		OpenTime:         50 * time.Millisecond,
+		HalfOpenRequests: 2,
+	})
+	b := getBreaker("test")
+
+	for i := 0; i < 4; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("closed breaker reject request: %v", err)
+		}
+		b.done(i%2 == 0, time.Millisecond)
+	}
+	if b.allow() != ErrBreakerOpen {
+		t.Fatalf("breaker should be open, got %s", b.stat().State)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if err := b.allow(); err != nil {
+			t.Fatalf("half-open breaker reject probe: %v", err)
+		}
+	}
+	if b.allow() != ErrBreakerOpen {
+		t.Fatal("half-open breaker should limit probes")
+	}
+	b.done(false, time.Millisecond)
+	b.done(false, time.Millisecond)
+	if state := b.stat().State; state != BreakerClosed {
+		t.Fatalf("breaker should be closed, got %s", state)
+	}
+}
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"github.com/spf13/viper"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type bulkheadRule struct {
+	routeRule      `mapstructure:",squash"`
+	MaxConcurrency int           `json:"max_concurrency" mapstructure:"max_concurrency"` //最大并发数
+	MaxQueue       int           `json:"max_queue" mapstructure:"max_queue"`             //最大排队数，0为不排队
+	QueueTimeout   time.Duration `json:"queue_timeout" mapstructure:"queue_timeout"`     //排队的最长时间
+}
+
+type bulkheadConfig struct {
+	Enable bool           `json:"enable" mapstructure:"enable"`
+	Rules  []bulkheadRule `json:"rules" mapstructure:"rules"` //以*结尾的规则对整个分组共享并发数
+}
+
+var bulkheadConf atomic.Value
+
+func initBulkheadConfig(v *viper.Viper) {
+	conf, err := parseBulkheadConfig(v)
+	storeConfig("bulkhead", &bulkheadConf, conf, err)
+}
+
+func parseBulkheadConfig(v *viper.Viper) (bulkheadConfig, error) {
+	conf := bulkheadConfig{}
+	if err := v.UnmarshalKey("bulkhead", &conf); err != nil {
+		return bulkheadConfig{}, err
+	}
+	for i := range conf.Rules {
+		if conf.Rules[i].QueueTimeout == 0 {
+			conf.Rules[i].QueueTimeout = time.Second
+		}
+	}
+	return conf, nil
+}
+
+func loadBulkheadConfig() bulkheadConfig {
+	conf, _ := bulkheadConf.Load().(bulkheadConfig)
+	return conf
+}
+
+// BulkheadStat 隔离舱的当前状态
+type BulkheadStat struct {
+	Name           string `json:"name"`
+	MaxConcurrency int    `json:"max_concurrency"`
+	MaxQueue       int    `json:"max_queue"`
+	InFlight       int64  `json:"in_flight"` //正在处理的请求数
+	Queued         int64  `json:"queued"`    //正在排队的请求数
+	Rejected       int64  `json:"rejected"`  //累计拒绝的请求数
+}
+
+type bulkhead struct {
+	name     string
+	rule     bulkheadRule
+	slots    chan struct{}
+	inFlight int64
+	queued   int64
+	rejected int64
+}
+
+var (
+	bulkheads     = map[string]*bulkhead{}
+	bulkheadMutex sync.RWMutex
+)
+
+// BulkheadStats 获取所有隔离舱的状态，用于指标上报
+func BulkheadStats() []BulkheadStat {
+	bulkheadMutex.RLock()
+	defer bulkheadMutex.RUnlock()
+	stats := make([]BulkheadStat, 0, len(bulkheads))
+	for _, item := range bulkheads {
+		stats = append(stats, BulkheadStat{
+			Name:           item.name,
+			MaxConcurrency: item.rule.MaxConcurrency,
+			MaxQueue:       item.rule.MaxQueue,
+			InFlight:       atomic.LoadInt64(&item.inFlight),
+			Queued:         atomic.LoadInt64(&item.queued),
+			Rejected:       atomic.LoadInt64(&item.rejected),
+		})
+	}
+	return stats
+}
+
+// getBulkhead 按规则获取隔离舱，配置变更后重新创建，处理中的请求仍在原隔离舱中释放
+func getBulkhead(rule bulkheadRule) *bulkhead {
+	name := strings.TrimSpace(rule.Method + " " + rule.Path)
+	bulkheadMutex.RLock()
+	b, ok := bulkheads[name]
+	bulkheadMutex.RUnlock()
+	if ok && b.rule == rule {
+		return b
+	}
+
+	bulkheadMutex.Lock()
+	defer bulkheadMutex.Unlock()
+	if b, ok = bulkheads[name]; ok && b.rule == rule {
+		return b
+	}
+	b = &bulkhead{name: name, rule: rule, slots: make(chan struct{}, rule.MaxConcurrency)}
+	bulkheads[name] = b
+	return b
+}
+
+// acquire 获取执行名额，并发已满时排队等待
+func (b *bulkhead) acquire(ctx *Context) bool {
+	select {
+	case b.slots <- struct{}{}:
+		atomic.AddInt64(&b.inFlight, 1)
+		return true
+	default:
+	}
+
+	if atomic.AddInt64(&b.queued, 1) > int64(b.rule.MaxQueue) {
+		atomic.AddInt64(&b.queued, -1)
+		atomic.AddInt64(&b.rejected, 1)
+		return false
+	}
+	defer atomic.AddInt64(&b.queued, -1)
+
+	timer := time.NewTimer(b.rule.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case b.slots <- struct{}{}:
+		atomic.AddInt64(&b.inFlight, 1)
+		return true
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+	atomic.AddInt64(&b.rejected, 1)
+	return false
+}
+
+func (b *bulkhead) release() {
+	atomic.AddInt64(&b.inFlight, -1)
+	<-b.slots
+}
+
+// Bulkhead 并发隔离，限制路由或分组的最大并发数，超出后排队，队列已满或排队超时返回503
+func Bulkhead() HandlerFunc {
+	return func(ctx *Context) {
+		conf := loadBulkheadConfig()
+		if !conf.Enable {
+			return
+		}
+		var rule *bulkheadRule
+		for i := range conf.Rules {
+			if conf.Rules[i].match(ctx) {
+				rule = &conf.Rules[i]
+				break
+			}
+		}
+		if rule == nil || rule.MaxConcurrency <= 0 {
+			return
+		}
+
+		b := getBulkhead(*rule)
+		if !b.acquire(ctx) {
+			ctx.SetHeader("Retry-After", "1")
+			ctx.Fail(http.StatusServiceUnavailable, "server is busy")
+			return
+		}
+		defer b.release()
+		ctx.Next()
+	}
+}
@@ -0,0 +1,99 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBulkhead(t *testing.T) {
+	rule := bulkheadRule{routeRule: routeRule{Path: "/bulkhead/*"}, MaxConcurrency: 2, MaxQueue: 1, QueueTimeout: 100 * time.Millisecond}
+	bulkheadConf.Store(bulkheadConfig{Enable: true, Rules: []bulkheadRule{rule}})
+	defer bulkheadConf.Store(bulkheadConfig{})
+
+	release := make(chan struct{})
+	var entered int32
+	handler := Bulkhead()
+	serve := func(block bool) int {
+		w := httptest.NewRecorder()
+		ctx := newContext(w, httptest.NewRequest(http.MethodGet, "/bulkhead/a", nil))
+		ctx.handlers = []HandlerFunc{recovery(), handler, func(ctx *Context) {
+			atomic.AddInt32(&entered, 1)
+			if block {
+				<-release
+			}
+			ctx.String(http.StatusOK, "ok")
+		}}
+		ctx.Next()
+		return w.Code
+	}
+	b := getBulkhead(rule)
+	waitFor := func(name string, fn func() bool) {
+		deadline := time.Now().Add(time.Second)
+		for !fn() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timeout waiting for %s", name)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	var wg sync.WaitGroup
+	codes := make([]int, 3)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			codes[i] = serve(true)
+		}(i)
+	}
+	// 两个请求执行中，一个请求排队
+	waitFor("slots", func() bool {
+		return atomic.LoadInt64(&b.inFlight) == 2 && atomic.LoadInt64(&b.queued) == 1
+	})
+	if code := serve(false); code != http.StatusServiceUnavailable {
+		t.Fatalf("request should be rejected when queue is full, got %d", code)
+	}
+	close(release)
+	wg.Wait()
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d: status = %d", i, code)
+		}
+	}
+
+	if b.inFlight != 0 || b.queued != 0 || b.rejected != 1 || len(b.slots) != 0 {
+		t.Errorf("after release: in flight %d, queued %d, rejected %d, slots %d", b.inFlight, b.queued, b.rejected, len(b.slots))
+	}
+
+	// 排队超时
+	b.slots <- struct{}{}
+	b.slots <- struct{}{}
+	start := time.Now()
+	if code := serve(false); code != http.StatusServiceUnavailable || time.Since(start) < rule.QueueTimeout {
+		t.Fatalf("queued request should time out, got %d after %s", code, time.Since(start))
+	}
+	<-b.slots
+	<-b.slots
+	if b.rejected != 2 || b.queued != 0 {
+		t.Errorf("queue timeout: rejected %d, queued %d", b.rejected, b.queued)
+	}
+
+	// panic时同样释放名额
+	w := httptest.NewRecorder()
+	ctx := newContext(w, httptest.NewRequest(http.MethodGet, "/bulkhead/a", nil))
+	ctx.handlers = []HandlerFunc{recovery(), handler, func(ctx *Context) { panic("boom") }}
+	ctx.Next()
+	if b.inFlight != 0 || len(b.slots) != 0 {
+		t.Errorf("panic should release slot, in flight %d, slots %d", b.inFlight, len(b.slots))
+	}
+
+	// 配置变更后使用新的隔离舱
+	rule.MaxConcurrency = 3
+	if getBulkhead(rule) == b {
+		t.Error("bulkhead should be recreated when rule changes")
+	}
+}
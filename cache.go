@@ -0,0 +1,408 @@
+package core
+
+import (
+	"container/list"
+	"context"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+	"github.com/zeromicro/go-zero/core/syncx"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type cacheRoute struct {
+	routeRule `mapstructure:",squash"`
+	TTL       time.Duration `json:"ttl" mapstructure:"ttl"`           //缓存时间，响应中声明了 s-maxage/max-age 时以响应为准
+	Query     []string      `json:"query" mapstructure:"query"`       //参与缓存key的查询参数，为空时使用全部参数
+	Vary      []string      `json:"vary" mapstructure:"vary"`         //参与缓存key的请求头
+	PerUser   bool          `json:"per_user" mapstructure:"per_user"` //是否按用户隔离缓存
+}
+
+type cacheConfig struct {
+	Enable     bool         `json:"enable" mapstructure:"enable"`
+	Store      string       `json:"store" mapstructure:"store"`             //缓存存储 local/redis
+	Prefix     string       `json:"prefix" mapstructure:"prefix"`           //redis中的key前缀
+	MaxEntries int          `json:"max_entries" mapstructure:"max_entries"` //本地缓存的最大条数，超出后按LRU淘汰
+	Routes     []cacheRoute `json:"routes" mapstructure:"routes"`
+}
+
+// cacheRecord 缓存的响应
+type cacheRecord struct {
+	Status   int         `json:"status"`
+	Header   http.Header `json:"header"`
+	Body     []byte      `json:"body"`
+	StoredAt time.Time   `json:"stored_at"`
+}
+
+var cacheConf atomic.Value
+
+func initCacheConfig(v *viper.Viper) {
+	conf, err := parseCacheConfig(v)
+	storeConfig("cache", &cacheConf, conf, err)
+}
+
+func parseCacheConfig(v *viper.Viper) (cacheConfig, error) {
+	conf := cacheConfig{}
+	if err := v.UnmarshalKey("cache", &conf); err != nil {
+		return cacheConfig{}, err
+	}
+	if conf.Store == "" {
+		conf.Store = "local"
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = "http_cache"
+	}
+	if conf.MaxEntries == 0 {
+		conf.MaxEntries = 10000
+	}
+	for i := range conf.Routes {
+		if conf.Routes[i].Method == "" {
+			conf.Routes[i].Method = http.MethodGet
+		}
+		if conf.Routes[i].TTL == 0 {
+			conf.Routes[i].TTL = time.Minute
+		}
+	}
+	return conf, nil
+}
+
+func loadCacheConfig() cacheConfig {
+	conf, _ := cacheConf.Load().(cacheConfig)
+	return conf
+}
+
+type cacheStore interface {
+	get(ctx context.Context, key string) (*cacheRecord, error)
+	set(ctx context.Context, key string, record *cacheRecord, ttl time.Duration) error
+	purge(ctx context.Context, prefix string) (int, error)
+}
+
+type localCacheEntry struct {
+	key      string
+	record   *cacheRecord
+	expireAt time.Time
+}
+
+// localCacheStore 本地LRU缓存
+type localCacheStore struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+}
+
+var localCache = &localCacheStore{entries: map[string]*list.Element{}, lru: list.New()}
+
+func (s *localCacheStore) get(_ context.Context, key string) (*cacheRecord, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	elem, ok := s.entries[key]
+	if !ok {
+		return nil, nil
+	}
+	entry := elem.Value.(*localCacheEntry)
+	if time.Now().After(entry.expireAt) {
+		s.lru.Remove(elem)
+		delete(s.entries, key)
+		return nil, nil
+	}
+	s.lru.MoveToFront(elem)
+	return entry.record, nil
+}
+
+func (s *localCacheStore) set(_ context.Context, key string, record *cacheRecord, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	entry := &localCacheEntry{key: key, record: record, expireAt: time.Now().Add(ttl)}
+	if elem, ok := s.entries[key]; ok {
+		elem.Value = entry
+		s.lru.MoveToFront(elem)
+		return nil
+	}
+	s.entries[key] = s.lru.PushFront(entry)
+	for max := loadCacheConfig().MaxEntries; max > 0 && s.lru.Len() > max; {
+		elem := s.lru.Back()
+		s.lru.Remove(elem)
+		delete(s.entries, elem.Value.(*localCacheEntry).key)
+	}
+	return nil
+}
+
+func (s *localCacheStore) purge(_ context.Context, prefix string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	count := 0
+	for key, elem := range s.entries {
+		if strings.HasPrefix(key, prefix) {
+			s.lru.Remove(elem)
+			delete(s.entries, key)
+			count++
+		}
+	}
+	return count, nil
+}
+
+type redisCacheStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func (s *redisCacheStore) get(ctx context.Context, key string) (*cacheRecord, error) {
+	data, err := s.client.Get(ctx, s.prefix+":"+key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := &cacheRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+func (s *redisCacheStore) set(ctx context.Context, key string, record *cacheRecord, ttl time.Duration) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return s.client.Set(ctx, s.prefix+":"+key, data, ttl).Err()
+}
+
+// globEscaper 转义redis SCAN 匹配模式中的特殊字符
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+func (s *redisCacheStore) purge(ctx context.Context, prefix string) (int, error) {
+	match := globEscaper.Replace(s.prefix+":"+prefix) + "*"
+	count := 0
+	var cursor uint64
+	for {
+		keys, next, err := s.client.Scan(ctx, cursor, match, 500).Result()
+		if err != nil {
+			return count, err
+		}
+		if len(keys) != 0 {
+			if err := s.client.Del(ctx, keys...).Err(); err != nil {
+				return count, err
+			}
+			count += len(keys)
+		}
+		if next == 0 {
+			return count, nil
+		}
+		cursor = next
+	}
+}
+
+func getCacheStore(conf cacheConfig) cacheStore {
+	if conf.Store == "redis" && globalRedisConnect != nil {
+		return &redisCacheStore{client: globalRedisConnect, prefix: conf.Prefix}
+	}
+	return localCache
+}
+
+// PurgeCache 清除以指定前缀开头的缓存，缓存key以路由规则开头，例如 /user/ 清除所有 /user/ 下路由的缓存
+func PurgeCache(ctx context.Context, prefix string) (int, error) {
+	return getCacheStore(loadCacheConfig()).purge(ctx, prefix)
+}
+
+// cacheKey 路由规则 + 查询参数 + 请求头 + 用户
+func cacheKey(ctx *Context, route *cacheRoute) string {
+	pattern := ctx.Pattern
+	if pattern == "" {
+		pattern = ctx.Path
+	}
+	var key strings.Builder
+	key.WriteString(pattern)
+	// 路由参数不同时请求的路径不同
+	if ctx.Path != pattern {
+		key.WriteString("|" + ctx.Path)
+	}
+
+	query := ctx.Request.URL.Query()
+	names := route.Query
+	if len(names) == 0 {
+		for name := range query {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	values := url.Values{}
+	for _, name := range names {
+		if val, ok := query[name]; ok {
+			values[name] = val
+		}
+	}
+	key.WriteString("?" + values.Encode())
+
+	for _, name := range route.Vary {
+		key.WriteString("|" + name + "=" + ctx.Request.Header.Get(name))
+	}
+	if route.PerUser {
+		key.WriteString("|user=" + ctx.GetString(UserIDKey))
+	}
+	return key.String()
+}
+
+// cacheControl 解析 Cache-Control 指令
+func cacheControl(value string) map[string]string {
+	res := map[string]string{}
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		parts := strings.SplitN(item, "=", 2)
+		name := strings.ToLower(parts[0])
+		if len(parts) == 2 {
+			res[name] = strings.Trim(parts[1], `"`)
+			continue
+		}
+		res[name] = ""
+	}
+	return res
+}
+
+// cacheTTL 判断响应是否可以缓存并返回缓存时间
+func cacheTTL(status int, header http.Header, ttl time.Duration) (time.Duration, bool) {
+	if status != http.StatusOK || header.Get("Set-Cookie") != "" {
+		return 0, false
+	}
+	directives := cacheControl(header.Get("Cache-Control"))
+	for _, name := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[name]; ok {
+			return 0, false
+		}
+	}
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if val, ok := directives[name]; ok {
+			seconds, err := strconv.Atoi(val)
+			if err != nil || seconds <= 0 {
+				return 0, false
+			}
+			return time.Duration(seconds) * time.Second, true
+		}
+	}
+	return ttl, true
+}
+
+// cacheWriter 在输出响应的同时保存完整的响应体
+type cacheWriter struct {
+	ResponseWriter
+	body []byte
+}
+
+func (w *cacheWriter) Write(b []byte) (int, error) {
+	w.body = append(w.body, b...)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *cacheWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// replay 输出缓存的响应
+func (r *cacheRecord) replay(ctx *Context) {
+	header := ctx.Writer.Header()
+	for key, val := range r.Header {
+		header[key] = val
+	}
+	header.Set("X-Cache", "HIT")
+	header.Set("Age", strconv.Itoa(int(time.Since(r.StoredAt)/time.Second)))
+	ctx.Status(r.Status)
+	if ctx.Method != http.MethodHead {
+		ctx.Writer.Write(r.Body)
+	}
+	ctx.Abort()
+}
+
+// Cache 响应缓存，仅缓存GET/HEAD请求的200响应，同一个key的并发请求只执行一次
+func Cache() HandlerFunc {
+	flight := syncx.NewSingleFlight()
+	return func(ctx *Context) {
+		conf := loadCacheConfig()
+		if !conf.Enable || (ctx.Method != http.MethodGet && ctx.Method != http.MethodHead) {
+			return
+		}
+		var route *cacheRoute
+		for i := range conf.Routes {
+			rule := conf.Routes[i].routeRule
+			// HEAD 请求复用 GET 的缓存
+			if ctx.Method == http.MethodHead && strings.EqualFold(rule.Method, http.MethodGet) {
+				rule.Method = ""
+			}
+			if rule.match(ctx) {
+				route = &conf.Routes[i]
+				break
+			}
+		}
+		if route == nil {
+			return
+		}
+
+		directives := cacheControl(ctx.Request.Header.Get("Cache-Control"))
+		if _, ok := directives["no-store"]; ok {
+			return
+		}
+		store := getCacheStore(conf)
+		key := cacheKey(ctx, route)
+		if _, ok := directives["no-cache"]; !ok {
+			record, err := store.get(ctx, key)
+			if err != nil {
+				ctx.Log.Error("cache load fail", zap.Error(err))
+			}
+			if record != nil {
+				record.replay(ctx)
+				return
+			}
+		}
+
+		val, fresh, _ := flight.DoEx(key, func() (interface{}, error) {
+			w := ctx.Writer
+			cw := &cacheWriter{ResponseWriter: w}
+			ctx.Writer = cw
+			defer func() {
+				ctx.Writer = w
+			}()
+			w.Header().Set("X-Cache", "MISS")
+			ctx.Next()
+
+			header := w.Header().Clone()
+			header.Del("X-Cache")
+			ttl, ok := cacheTTL(w.Status(), header, route.TTL)
+			if !ok {
+				return nil, nil
+			}
+			// HEAD 请求没有响应体，不能作为 GET 的缓存
+			if ctx.Method == http.MethodHead {
+				return nil, nil
+			}
+			record := &cacheRecord{Status: w.Status(), Header: header, Body: cw.body, StoredAt: time.Now()}
+			// 客户端断开不影响缓存的写入
+			if err := store.set(context.Background(), key, record, ttl); err != nil {
+				ctx.Log.Error("cache store fail", zap.Error(err))
+			}
+			return record, nil
+		})
+		if fresh {
+			return
+		}
+		// 等待的请求直接使用首个请求的结果，结果不可缓存时自行处理
+		if record, ok := val.(*cacheRecord); ok && record != nil {
+			record.replay(ctx)
+			return
+		}
+		ctx.Writer.Header().Set("X-Cache", "MISS")
+		ctx.Next()
+	}
+}
@@ -0,0 +1,130 @@
+package core
+
+import (
+	"container/list"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestLocalCacheLRU(t *testing.T) {
+	cacheConf.Store(cacheConfig{MaxEntries: 2})
+	defer cacheConf.Store(cacheConfig{})
+	s := &localCacheStore{entries: map[string]*list.Element{}, lru: list.New()}
+	ctx := context.Background()
+
+	s.set(ctx, "/a", &cacheRecord{Body: []byte("a")}, time.Minute)
+	s.set(ctx, "/b", &cacheRecord{Body: []byte("b")}, time.Minute)
+	// 访问 /a 后 /b 成为最久未使用的条目
+	s.get(ctx, "/a")
+	s.set(ctx, "/c", &cacheRecord{Body: []byte("c")}, time.Minute)
+	s.set(ctx, "/d", &cacheRecord{Body: []byte("d")}, -time.Second)
+
+	tests := []struct {
+		key string
+		hit bool
+	}{
+		{"/a", false},
+		{"/b", false},
+		{"/c", true},
+		{"/d", false},
+	}
+	for _, tt := range tests {
+		record, _ := s.get(ctx, tt.key)
+		if (record != nil) != tt.hit {
+			t.Errorf("%s: hit = %v, want %v", tt.key, record != nil, tt.hit)
+		}
+	}
+
+	s.set(ctx, "/user/1", &cacheRecord{}, time.Minute)
+	s.set(ctx, "/user/2", &cacheRecord{}, time.Minute)
+	if count, _ := s.purge(ctx, "/user/"); count != 2 || s.lru.Len() != 0 {
+		t.Errorf("purge removed %d entries, %d left", count, s.lru.Len())
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		header    http.Header
+		ttl       time.Duration
+		cacheable bool
+	}{
+		{"default", http.StatusOK, http.Header{}, time.Minute, true},
+		{"max-age", http.StatusOK, http.Header{"Cache-Control": {"public, max-age=30"}}, 30 * time.Second, true},
+		{"s-maxage first", http.StatusOK, http.Header{"Cache-Control": {"max-age=30, s-maxage=10"}}, 10 * time.Second, true},
+		{"no-store", http.StatusOK, http.Header{"Cache-Control": {"no-store"}}, 0, false},
+		{"private", http.StatusOK, http.Header{"Cache-Control": {"private, max-age=30"}}, 0, false},
+		{"set-cookie", http.StatusOK, http.Header{"Set-Cookie": {"a=1"}}, 0, false},
+		{"not ok", http.StatusNotFound, http.Header{}, 0, false},
+	}
+	for _, tt := range tests {
+		ttl, ok := cacheTTL(tt.status, tt.header, time.Minute)
+		if ok != tt.cacheable || ttl != tt.ttl {
+			t.Errorf("%s: cacheTTL = %v %v, want %v %v", tt.name, ttl, ok, tt.ttl, tt.cacheable)
+		}
+	}
+}
+
+func TestCacheSingleFlight(t *testing.T) {
+	cacheConf.Store(cacheConfig{Enable: true, Store: "local", MaxEntries: 100, Routes: []cacheRoute{
+		{routeRule: routeRule{Method: http.MethodGet, Path: "/cache/*"}, TTL: time.Minute},
+	}})
+	defer func() {
+		localCache.purge(context.Background(), "/cache/")
+		cacheConf.Store(cacheConfig{})
+	}()
+
+	var calls int32
+	release := make(chan struct{})
+	cache := Cache()
+	serve := func(method string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		ctx := newContext(w, httptest.NewRequest(method, "/cache/list?b=2&a=1", nil))
+		ctx.Pattern = "/cache/list"
+		ctx.handlers = []HandlerFunc{cache, func(ctx *Context) {
+			atomic.AddInt32(&calls, 1)
+			<-release
+			ctx.String(http.StatusOK, "list")
+		}}
+		ctx.Next()
+		return w
+	}
+
+	var wg sync.WaitGroup
+	results := make([]*httptest.ResponseRecorder, 5)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = serve(http.MethodGet)
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("concurrent requests should call handler once, got %d", calls)
+	}
+	for i, w := range results {
+		if w.Code != http.StatusOK || w.Body.String() != "list" {
+			t.Errorf("request %d: got %d %q", i, w.Code, w.Body.String())
+		}
+	}
+
+	if w := serve(http.MethodGet); w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "list" {
+		t.Errorf("cached request: X-Cache = %q, body %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	if w := serve(http.MethodHead); w.Header().Get("X-Cache") != "HIT" || w.Body.Len() != 0 {
+		t.Errorf("HEAD should reuse GET cache without body, X-Cache = %q", w.Header().Get("X-Cache"))
+	}
+	if calls != 1 {
+		t.Fatalf("cached requests should not call handler, got %d", calls)
+	}
+}
@@ -0,0 +1,157 @@
+package core
+
+import (
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"hash/fnv"
+	"net"
+	"sync/atomic"
+)
+
+// CanaryStable 默认版本，未命中任何灰度规则时使用
+const CanaryStable = "stable"
+
+// CanaryVersionKey 当前请求命中的版本在上下文中的key
+var CanaryVersionKey = "canary_version"
+
+type canaryRule struct {
+	routeRule      `mapstructure:",squash"`
+	Version        string            `json:"version" mapstructure:"version"`                 //命中规则时使用的版本
+	Headers        map[string]string `json:"headers" mapstructure:"headers"`                 //请求头全部匹配时命中，例如 x-gray: 1，值为空时只要求请求头存在
+	Users          []string          `json:"users" mapstructure:"users"`                     //指定的用户ID
+	UserPercentage float64           `json:"user_percentage" mapstructure:"user_percentage"` //按用户ID哈希命中的百分比，同一用户的结果固定
+	IPs            []string          `json:"ips" mapstructure:"ips"`                         //指定的ip或网段，经过代理时需要配置 system.trusted_proxies
+
+	users  map[string]bool
+	ipNets []*net.IPNet
+}
+
+type canaryConfig struct {
+	Enable bool         `json:"enable" mapstructure:"enable"`
+	Rules  []canaryRule `json:"rules" mapstructure:"rules"` //按顺序匹配，第一个命中的规则生效
+}
+
+var canaryConf atomic.Value
+
+func initCanaryConfig(v *viper.Viper) {
+	conf, err := parseCanaryConfig(v)
+	storeConfig("canary", &canaryConf, conf, err)
+}
+
+func parseCanaryConfig(v *viper.Viper) (*canaryConfig, error) {
+	conf := canaryConfig{}
+	if err := v.UnmarshalKey("canary", &conf); err != nil {
+		return nil, err
+	}
+	for i := range conf.Rules {
+		rule := &conf.Rules[i]
+		rule.users = map[string]bool{}
+		for _, id := range rule.Users {
+			rule.users[id] = true
+		}
+		for _, item := range rule.IPs {
+			ipNet, err := parseIPNet(item)
+			if err != nil {
+				return nil, err
+			}
+			rule.ipNets = append(rule.ipNets, ipNet)
+		}
+	}
+	return &conf, nil
+}
+
+func loadCanaryConfig() *canaryConfig {
+	conf, _ := canaryConf.Load().(*canaryConfig)
+	if conf == nil {
+		return &canaryConfig{}
+	}
+	return conf
+}
+
+// userBucket 用户ID哈希到0-9999，支持0.01%粒度的灰度比例，加上版本名使不同版本的灰度用户相互独立
+func userBucket(version, userID string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(version + ":" + userID))
+	return float64(h.Sum32() % 10000)
+}
+
+// hit 判断请求是否命中规则，请求头、用户、用户比例、ip 任一条件满足即命中
+func (r *canaryRule) hit(ctx *Context) bool {
+	if len(r.Headers) != 0 {
+		matched := true
+		for name, val := range r.Headers {
+			if got := ctx.Request.Header.Get(name); got == "" || (val != "" && got != val) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return true
+		}
+	}
+	if userID := ctx.GetString(UserIDKey); userID != "" {
+		if r.users[userID] {
+			return true
+		}
+		if r.UserPercentage > 0 && userBucket(r.Version, userID) < r.UserPercentage*100 {
+			return true
+		}
+	}
+	if len(r.ipNets) != 0 {
+		if ip := net.ParseIP(ctx.ClientIP()); ip != nil {
+			for _, item := range r.ipNets {
+				if item.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// canaryVersion 根据灰度规则选择版本，规则指定的版本未注册时跳过
+func canaryVersion(ctx *Context, versions map[string]HandlerFunc) string {
+	conf := loadCanaryConfig()
+	if !conf.Enable {
+		return CanaryStable
+	}
+	for i := range conf.Rules {
+		rule := &conf.Rules[i]
+		if _, ok := versions[rule.Version]; !ok || !rule.match(ctx) {
+			continue
+		}
+		if rule.hit(ctx) {
+			return rule.Version
+		}
+	}
+	return CanaryStable
+}
+
+// Canary 同一路由注册多个版本的处理函数，按 canary 配置的规则选择版本，必须包含 stable 版本
+//
+//	r.GET("/order/:id", core.Canary(map[string]core.HandlerFunc{
+//		core.CanaryStable: getOrder,
+//		"v2":              getOrderV2,
+//	}))
+func Canary(versions map[string]HandlerFunc) HandlerFunc {
+	if versions[CanaryStable] == nil {
+		panic("canary 缺少 " + CanaryStable + " 版本")
+	}
+	handlers := make(map[string]HandlerFunc, len(versions))
+	for name, handler := range versions {
+		handlers[name] = handler
+	}
+	return func(ctx *Context) {
+		version := canaryVersion(ctx, handlers)
+		ctx.SetValue(CanaryVersionKey, version)
+		if version != CanaryStable {
+			ctx.Log = ctx.Log.With(zap.String(CanaryVersionKey, version))
+		}
+		handlers[version](ctx)
+	}
+}
+
+// CanaryVersion 当前请求命中的版本，未使用 Canary 注册的路由返回空
+func (c *Context) CanaryVersion() string {
+	return c.GetString(CanaryVersionKey)
+}
@@ -0,0 +1,114 @@
+package core
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+)
+
+func TestCanary(t *testing.T) {
+	system := globalSystemConfig
+	proxy, _ := parseIPNet("10.0.0.1")
+	globalSystemConfig.trustedNets = []*net.IPNet{proxy}
+	defer func() { globalSystemConfig = system }()
+
+	conf := &canaryConfig{Enable: true, Rules: []canaryRule{
+		{routeRule: routeRule{Path: "/order/*"}, Version: "v2", Headers: map[string]string{"X-Gray": "1"}},
+		{routeRule: routeRule{Path: "/order/*"}, Version: "v3", Headers: map[string]string{"X-Beta": ""}},
+		{routeRule: routeRule{Path: "/order/*"}, Version: "v2", users: map[string]bool{"7": true}},
+		{routeRule: routeRule{Path: "/order/*"}, Version: "v2", ipNets: []*net.IPNet{{IP: net.ParseIP("192.168.1.0").To4(), Mask: net.CIDRMask(24, 32)}}},
+		{routeRule: routeRule{Path: "/order/*"}, Version: "missing", users: map[string]bool{"8": true}},
+	}}
+	canaryConf.Store(conf)
+	defer canaryConf.Store(&canaryConfig{})
+
+	handler := Canary(map[string]HandlerFunc{
+		CanaryStable: func(ctx *Context) { ctx.String(http.StatusOK, CanaryStable) },
+		"v2":         func(ctx *Context) { ctx.String(http.StatusOK, "v2") },
+		"v3":         func(ctx *Context) { ctx.String(http.StatusOK, "v3") },
+	})
+	tests := []struct {
+		name    string
+		path    string
+		remote  string
+		header  map[string]string
+		userID  string
+		version string
+	}{
+		{"stable", "/order/1", "1.1.1.1:80", nil, "", CanaryStable},
+		{"header", "/order/1", "1.1.1.1:80", map[string]string{"X-Gray": "1"}, "", "v2"},
+		{"header mismatch", "/order/1", "1.1.1.1:80", map[string]string{"X-Gray": "0"}, "", CanaryStable},
+		{"header presence", "/order/1", "1.1.1.1:80", map[string]string{"X-Beta": "yes"}, "", "v3"},
+		{"user", "/order/1", "1.1.1.1:80", nil, "7", "v2"},
+		{"ip", "/order/1", "192.168.1.9:80", nil, "", "v2"},
+		{"ip via trusted proxy", "/order/1", "10.0.0.1:80", map[string]string{"X-Forwarded-For": "192.168.1.9"}, "", "v2"},
+		{"spoofed ip", "/order/1", "1.1.1.1:80", map[string]string{"X-Forwarded-For": "192.168.1.9"}, "", CanaryStable},
+		{"missing version", "/order/1", "1.1.1.1:80", nil, "8", CanaryStable},
+		{"other route", "/user/1", "1.1.1.1:80", map[string]string{"X-Gray": "1"}, "", CanaryStable},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		r.RemoteAddr = tt.remote
+		for name, val := range tt.header {
+			r.Header.Set(name, val)
+		}
+		ctx := newContext(w, r)
+		if tt.userID != "" {
+			ctx.SetValue(UserIDKey, tt.userID)
+		}
+		var version string
+		ctx.handlers = []HandlerFunc{func(ctx *Context) {
+			ctx.Next()
+			version = ctx.CanaryVersion()
+		}, handler}
+		ctx.Next()
+		if w.Body.String() != tt.version || version != tt.version {
+			t.Errorf("%s: version = %s (context %s), want %s", tt.name, w.Body.String(), version, tt.version)
+		}
+	}
+}
+
+func TestCanaryHeaderRequired(t *testing.T) {
+	rule := &canaryRule{Headers: map[string]string{"X-Gray": ""}}
+	ctx := newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if rule.hit(ctx) {
+		t.Fatal("request without header should not match")
+	}
+}
+
+func TestCanaryUserPercentage(t *testing.T) {
+	// 同一用户的结果固定
+	if userBucket("v2", "42") != userBucket("v2", "42") {
+		t.Fatal("user bucket should be stable")
+	}
+	tests := []struct {
+		percentage float64
+	}{
+		{0.5},
+		{10},
+		{50},
+	}
+	const users = 20000
+	for _, tt := range tests {
+		rule := &canaryRule{Version: "v2", UserPercentage: tt.percentage}
+		hits := 0
+		for i := 0; i < users; i++ {
+			ctx := newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			ctx.SetValue(UserIDKey, strconv.Itoa(i))
+			if rule.hit(ctx) {
+				hits++
+			}
+		}
+		got := float64(hits) * 100 / users
+		if math.Abs(got-tt.percentage) > math.Max(tt.percentage*0.2, 0.3) {
+			t.Errorf("percentage %v: got %.2f%%", tt.percentage, got)
+		}
+	}
+	if userBucket("v2", "42") == userBucket("v3", "42") && userBucket("v2", "43") == userBucket("v3", "43") {
+		t.Error("versions should use independent buckets")
+	}
+}
@@ -0,0 +1,344 @@
+package core
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/spf13/viper"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+type compressConfig struct {
+	Enable            bool     `json:"enable" mapstructure:"enable"`
+	Level             int      `json:"level" mapstructure:"level"`                           //压缩等级，gzip/deflate 1-9，br 0-11
+	MinSize           int      `json:"min_size" mapstructure:"min_size"`                     //响应体超过该大小才进行压缩
+	Encodings         []string `json:"encodings" mapstructure:"encodings"`                   //支持的压缩算法，按优先级排列
+	ContentTypes      []string `json:"content_types" mapstructure:"content_types"`           //需要压缩的响应类型，按前缀匹配
+	DecompressRequest bool     `json:"decompress_request" mapstructure:"decompress_request"` //是否解压请求体
+}
+
+var compressConf atomic.Value
+
+func initCompressConfig(v *viper.Viper) {
+	conf, err := parseCompressConfig(v)
+	storeConfig("compress", &compressConf, conf, err)
+}
+
+func parseCompressConfig(v *viper.Viper) (compressConfig, error) {
+	conf := compressConfig{}
+	if err := v.UnmarshalKey("compress", &conf); err != nil {
+		return compressConfig{}, err
+	}
+	if conf.Level == 0 {
+		conf.Level = 5
+	}
+	if conf.MinSize == 0 {
+		conf.MinSize = 1024
+	}
+	if len(conf.Encodings) == 0 {
+		conf.Encodings = []string{"br", "gzip", "deflate"}
+	}
+	if len(conf.ContentTypes) == 0 {
+		conf.ContentTypes = []string{
+			"text/html", "text/plain", "text/css", "text/xml",
+			"application/json", "application/javascript", "application/xml",
+		}
+	}
+	return conf, nil
+}
+
+func loadCompressConfig() compressConfig {
+	conf, _ := compressConf.Load().(compressConfig)
+	return conf
+}
+
+// encoder 可复用的压缩器
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var encoderPools sync.Map
+
+// getEncoder 从对象池中获取压缩器
+func getEncoder(encoding string, level int, w io.Writer) encoder {
+	key := encoding + ":" + strconv.Itoa(level)
+	pool, _ := encoderPools.LoadOrStore(key, &sync.Pool{New: func() interface{} {
+		switch encoding {
+		case "br":
+			return brotli.NewWriterLevel(nil, level)
+		case "gzip":
+			enc, err := gzip.NewWriterLevel(nil, level)
+			if err != nil {
+				enc = gzip.NewWriter(nil)
+			}
+			return enc
+		default:
+			enc, err := flate.NewWriter(nil, level)
+			if err != nil {
+				enc, _ = flate.NewWriter(nil, flate.DefaultCompression)
+			}
+			return enc
+		}
+	}})
+	enc := pool.(*sync.Pool).Get().(encoder)
+	enc.Reset(w)
+	return enc
+}
+
+func putEncoder(encoding string, level int, enc encoder) {
+	if pool, ok := encoderPools.Load(encoding + ":" + strconv.Itoa(level)); ok {
+		pool.(*sync.Pool).Put(enc)
+	}
+}
+
+// negotiateEncoding 根据 Accept-Encoding 选择压缩算法，q值相同时按配置的优先级
+func negotiateEncoding(accept string, encodings []string) string {
+	if accept == "" {
+		return ""
+	}
+	quality := map[string]float64{}
+	for _, item := range strings.Split(accept, ",") {
+		parts := strings.Split(strings.TrimSpace(item), ";")
+		name := strings.ToLower(strings.TrimSpace(parts[0]))
+		q := 1.0
+		for _, param := range parts[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if val, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = val
+				}
+			}
+		}
+		quality[name] = q
+	}
+
+	best, bestQ := "", 0.0
+	for _, name := range encodings {
+		q, ok := quality[name]
+		if !ok {
+			q, ok = quality["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressWriter 先缓存响应，超过阈值且类型匹配时再决定是否压缩
+type compressWriter struct {
+	ResponseWriter
+	conf     compressConfig
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	encoder  encoder
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if w.decided {
+		if w.encoder != nil {
+			return w.encoder.Write(b)
+		}
+		return w.ResponseWriter.Write(b)
+	}
+	w.buf = append(w.buf, b...)
+	if len(w.buf) >= w.conf.MinSize {
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(b), nil
+}
+
+// Flush 流式响应不进行压缩，已经在压缩的响应刷新压缩器
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(false)
+	}
+	if w.encoder != nil {
+		w.encoder.Flush()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *compressWriter) Status() int {
+	if !w.decided {
+		return w.status
+	}
+	return w.ResponseWriter.Status()
+}
+
+func (w *compressWriter) Written() bool {
+	return w.decided || w.buf != nil || w.status != http.StatusOK
+}
+
+// compressible 判断响应是否需要压缩
+func (w *compressWriter) compressible() bool {
+	header := w.Header()
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	if w.status < http.StatusOK || w.status == http.StatusNoContent || w.status == http.StatusNotModified {
+		return false
+	}
+	contentType := header.Get("Content-Type")
+	if contentType == "" {
+		contentType = http.DetectContentType(w.buf)
+	}
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		return false
+	}
+	for _, item := range w.conf.ContentTypes {
+		if strings.HasPrefix(contentType, item) {
+			return true
+		}
+	}
+	return false
+}
+
+// decide 确定是否压缩，并输出已缓存的数据
+// 可压缩类型的响应无论是否压缩都设置 Vary，避免缓存将压缩与未压缩的响应混用
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	if w.compressible() {
+		header := w.Header()
+		header.Add("Vary", "Accept-Encoding")
+		if compress && w.encoding != "" {
+			header.Set("Content-Encoding", w.encoding)
+			header.Del("Content-Length")
+			w.encoder = getEncoder(w.encoding, w.conf.Level, w.ResponseWriter)
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(w.buf)
+	} else {
+		_, err = w.ResponseWriter.Write(w.buf)
+	}
+	w.buf = nil
+	return err
+}
+
+// close 处理结束，输出剩余的数据并回收压缩器
+func (w *compressWriter) close() {
+	if !w.decided {
+		if w.buf == nil && w.status == http.StatusOK {
+			// 未写入任何响应时保持原样
+			return
+		}
+		w.decide(false)
+	}
+	if w.encoder != nil {
+		w.encoder.Close()
+	}
+	w.release()
+}
+
+// release 回收压缩器，不输出压缩的结尾数据
+func (w *compressWriter) release() {
+	if w.encoder != nil {
+		putEncoder(w.encoding, w.conf.Level, w.encoder)
+		w.encoder = nil
+	}
+}
+
+// decompressBody 解压请求体，解压后的长度同样受请求体大小的限制，防止压缩炸弹
+func decompressBody(r *http.Request, limit int64) (*maxBytesReader, error) {
+	var reader io.ReadCloser
+	switch strings.ToLower(r.Header.Get("Content-Encoding")) {
+	case "gzip":
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		reader = gz
+	case "deflate":
+		reader = flate.NewReader(r.Body)
+	default:
+		return nil, nil
+	}
+	var limited *maxBytesReader
+	if limit > 0 {
+		limited = &maxBytesReader{ReadCloser: reader, remain: limit}
+		reader = limited
+	}
+	r.Body = reader
+	r.Header.Del("Content-Encoding")
+	r.Header.Del("Content-Length")
+	r.ContentLength = -1
+	return limited, nil
+}
+
+// Compress 响应压缩及请求解压
+func Compress() HandlerFunc {
+	return func(ctx *Context) {
+		conf := loadCompressConfig()
+		if !conf.Enable {
+			return
+		}
+		var limited *maxBytesReader
+		if conf.DecompressRequest && ctx.Request.Body != nil && ctx.Request.Body != http.NoBody {
+			reader, err := decompressBody(ctx.Request, requestBodySize(ctx))
+			if err != nil {
+				ctx.Fail(http.StatusBadRequest, "request body decompress fail")
+				return
+			}
+			limited = reader
+		}
+
+		if ctx.Request.Header.Get("Upgrade") != "" {
+			if limited != nil {
+				ctx.Next()
+				limited.fail(ctx)
+			}
+			return
+		}
+		// 客户端不支持压缩时不压缩，但仍需要为可压缩的响应设置 Vary
+		encoding := ""
+		if ctx.Method != http.MethodHead {
+			encoding = negotiateEncoding(ctx.Request.Header.Get("Accept-Encoding"), conf.Encodings)
+		}
+
+		w := ctx.Writer
+		cw := &compressWriter{ResponseWriter: w, conf: conf, encoding: encoding, status: http.StatusOK}
+		ctx.Writer = cw
+		completed := false
+		defer func() {
+			ctx.Writer = w
+			if completed {
+				cw.close()
+				return
+			}
+			// 处理过程中发生panic时丢弃缓存的响应，由recovery输出错误信息，已输出的压缩数据不再补充结尾
+			cw.release()
+		}()
+		ctx.Next()
+		if limited != nil {
+			limited.fail(ctx)
+		}
+		completed = true
+	}
+}
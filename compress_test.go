@@ -0,0 +1,196 @@
+package core
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	encodings := []string{"br", "gzip", "deflate"}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"br;q=0, gzip", "gzip"},
+		{"*", "br"},
+		{"*;q=0.5, deflate", "deflate"},
+		{"identity", ""},
+		{"GZIP", "gzip"},
+	}
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.accept, encodings); got != tt.want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", tt.accept, got, tt.want)
+		}
+	}
+}
+
+func serveCompress(r *http.Request, handler HandlerFunc) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ctx := newContext(w, r)
+	ctx.handlers = []HandlerFunc{Compress(), handler}
+	ctx.Next()
+	return w
+}
+
+func TestCompress(t *testing.T) {
+	compressConf.Store(compressConfig{Enable: true, Level: 5, MinSize: 64, Encodings: []string{"br", "gzip", "deflate"}, ContentTypes: []string{"text/plain"}})
+	defer compressConf.Store(compressConfig{})
+
+	body := strings.Repeat("hello compress ", 100)
+	decoders := map[string]func(r io.Reader) io.Reader{
+		"gzip": func(r io.Reader) io.Reader {
+			gz, err := gzip.NewReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return gz
+		},
+		"deflate": func(r io.Reader) io.Reader { return flate.NewReader(r) },
+		"br":      func(r io.Reader) io.Reader { return brotli.NewReader(r) },
+	}
+	// 每种算法请求两次，第二次使用对象池中回收的压缩器
+	for _, encoding := range []string{"gzip", "deflate", "br", "gzip", "deflate", "br"} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", encoding)
+		w := serveCompress(r, func(ctx *Context) { ctx.String(http.StatusOK, body) })
+		if got := w.Header().Get("Content-Encoding"); got != encoding {
+			t.Fatalf("%s: Content-Encoding = %q", encoding, got)
+		}
+		data, err := ioutil.ReadAll(decoders[encoding](w.Body))
+		if err != nil || string(data) != body {
+			t.Fatalf("%s: decoded body mismatch, err %v", encoding, err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		handler HandlerFunc
+	}{
+		{"small body", func(ctx *Context) { ctx.String(http.StatusOK, "small") }},
+		{"content type", func(ctx *Context) { ctx.JSON(http.StatusOK, H{"data": body}) }},
+		{"no content", func(ctx *Context) { ctx.Status(http.StatusNoContent) }},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		if w := serveCompress(r, tt.handler); w.Header().Get("Content-Encoding") != "" {
+			t.Errorf("%s: should not be compressed", tt.name)
+		}
+	}
+}
+
+func TestDecompressRequest(t *testing.T) {
+	compressConf.Store(compressConfig{Enable: true, Level: 5, MinSize: 1024, Encodings: []string{"gzip"}, DecompressRequest: true})
+	globalSystemConfig.MaxBodySize = 1024
+	defer func() {
+		compressConf.Store(compressConfig{})
+		globalSystemConfig.MaxBodySize = 0
+	}()
+
+	gzipBody := func(size int) *bytes.Buffer {
+		buf := &bytes.Buffer{}
+		gz := gzip.NewWriter(buf)
+		gz.Write(bytes.Repeat([]byte("a"), size))
+		gz.Close()
+		return buf
+	}
+	handler := func(ctx *Context) {
+		body, err := ctx.Body()
+		if err != nil {
+			return
+		}
+		ctx.String(http.StatusOK, string(body))
+	}
+	tests := []struct {
+		name   string
+		body   io.Reader
+		accept string
+		status int
+	}{
+		{"within limit", gzipBody(512), "", http.StatusOK},
+		{"gzip bomb", gzipBody(1 << 20), "", http.StatusRequestEntityTooLarge},
+		{"gzip bomb with compressed response", gzipBody(1 << 20), "gzip", http.StatusRequestEntityTooLarge},
+		{"invalid gzip", strings.NewReader("not gzip"), "", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/", tt.body)
+		r.Header.Set("Content-Encoding", "gzip")
+		r.Header.Set("Accept-Encoding", tt.accept)
+		w := serveCompress(r, handler)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if tt.status == http.StatusOK && w.Body.Len() != 512 {
+			t.Errorf("%s: decompressed body length = %d", tt.name, w.Body.Len())
+		}
+	}
+}
+
+func TestCompressVary(t *testing.T) {
+	compressConf.Store(compressConfig{Enable: true, Level: 5, MinSize: 64, Encodings: []string{"gzip"}, ContentTypes: []string{"text/plain"}})
+	defer compressConf.Store(compressConfig{})
+
+	body := strings.Repeat("hello compress ", 100)
+	tests := []struct {
+		name     string
+		accept   string
+		handler  HandlerFunc
+		encoding string
+		vary     string
+	}{
+		{"compressed", "gzip", func(ctx *Context) { ctx.String(http.StatusOK, body) }, "gzip", "Accept-Encoding"},
+		{"identity client", "", func(ctx *Context) { ctx.String(http.StatusOK, body) }, "", "Accept-Encoding"},
+		{"small body", "gzip", func(ctx *Context) { ctx.String(http.StatusOK, "small") }, "", "Accept-Encoding"},
+		{"not compressible", "gzip", func(ctx *Context) { ctx.JSON(http.StatusOK, H{"data": body}) }, "", ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Accept-Encoding", tt.accept)
+		w := serveCompress(r, tt.handler)
+		if w.Header().Get("Content-Encoding") != tt.encoding || w.Header().Get("Vary") != tt.vary {
+			t.Errorf("%s: Content-Encoding %q Vary %q", tt.name, w.Header().Get("Content-Encoding"), w.Header().Get("Vary"))
+		}
+		if tt.name == "identity client" && w.Body.String() != body {
+			t.Errorf("%s: body should not be changed", tt.name)
+		}
+	}
+}
+
+func TestCompressPanic(t *testing.T) {
+	compressConf.Store(compressConfig{Enable: true, Level: 5, MinSize: 64, Encodings: []string{"gzip"}, ContentTypes: []string{"text/plain"}})
+	defer compressConf.Store(compressConfig{})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	ctx := newContext(w, r)
+	ctx.handlers = []HandlerFunc{func(ctx *Context) {
+		defer func() { recover() }()
+		ctx.Next()
+	}, Compress(), func(ctx *Context) {
+		ctx.String(http.StatusOK, strings.Repeat("hello compress ", 100))
+		ctx.Writer.(http.Flusher).Flush()
+		panic("boom")
+	}}
+	ctx.Next()
+	gz, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 未补充结尾数据，客户端可以识别出响应不完整
+	if _, err = ioutil.ReadAll(gz); err != io.ErrUnexpectedEOF {
+		t.Fatalf("partial response should not be terminated, got %v", err)
+	}
+}
@@ -4,9 +4,12 @@ import (
 	"core/config_drive"
 	"encoding/json"
 	"flag"
+	"github.com/spf13/viper"
 	"go.uber.org/zap"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -42,8 +45,63 @@ func newConfig(log *zap.Logger) *config {
 	}
 }
 
+var (
+	configWatchers []config_drive.CallFunc
+	configMutex    sync.RWMutex
+)
+
+// WatchConfig 监听配置变更，配置中心的数据变更后依次回调
 func WatchConfig(f config_drive.CallFunc) {
-	config_drive.CallBack = f
+	configMutex.Lock()
+	defer configMutex.Unlock()
+	configWatchers = append(configWatchers, f)
+}
+
+// notifyConfig 配置变更时通知所有的监听者
+func notifyConfig(v *viper.Viper) {
+	configMutex.RLock()
+	watchers := configWatchers
+	configMutex.RUnlock()
+	for _, f := range watchers {
+		f(v)
+	}
+}
+
+// hotConfigs 支持热更新的配置，启动时加载，配置中心变更时重新加载
+var hotConfigs = []config_drive.CallFunc{
+	initBreakerConfig,
+	initRateLimitConfig,
+	initCorsConfig,
+	initJwtConfig,
+	initSignatureConfig,
+	initRbacConfig,
+	initCompressConfig,
+	initMaskConfig,
+	initBodyLogConfig,
+	initSecurityConfig,
+	initCsrfConfig,
+	initIdempotencyConfig,
+	initCacheConfig,
+	initBulkheadConfig,
+	initRecoveryConfig,
+	initAlertConfig,
+	initSessionConfig,
+	initMaintenanceConfig,
+	initFaultConfig,
+	initCanaryConfig,
+}
+
+// storeConfig 保存解析后的配置，首次加载失败时panic，热更新失败时记录日志并继续使用原有的配置
+func storeConfig(name string, store *atomic.Value, conf interface{}, err error) bool {
+	if err != nil {
+		if store.Load() == nil {
+			panic(name + " 配置解析错误" + err.Error())
+		}
+		globalLog.Error(name+" config reload fail", zap.Error(err))
+		return false
+	}
+	store.Store(conf)
+	return true
 }
 
 var configFile = flag.String("c", "config/dev.json", "the config file path")
@@ -68,9 +126,14 @@ func initConfig() {
 			Path:  *configFile,
 		}
 	}
+	config_drive.CallBack = notifyConfig
 	globalConfig = config_drive.Init(&conf)
 	initLogKey(globalConfig)
 	initSystemConfig(globalConfig)
+	for _, f := range hotConfigs {
+		f(globalConfig)
+		WatchConfig(f)
+	}
 }
 
 func (c *config) Set(key string, value interface{}) {
@@ -34,5 +34,4 @@ func Init(conf *Config) *viper.Viper {
 	default:
 		panic("config type is fail")
 	}
-	return nil
 }
@@ -0,0 +1,59 @@
+package core
+
+import (
+	"github.com/spf13/viper"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStoreConfigReload(t *testing.T) {
+	var store atomic.Value
+	v := viper.New()
+	v.Set("breaker", map[string]interface{}{"window": "abc"})
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("first load with invalid config should panic")
+			}
+		}()
+		conf, err := parseBreakerConfig(v)
+		storeConfig("breaker", &store, conf, err)
+	}()
+
+	v.Set("breaker", map[string]interface{}{"window": "5s"})
+	conf, err := parseBreakerConfig(v)
+	if !storeConfig("breaker", &store, conf, err) {
+		t.Fatalf("valid config should be stored: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+		parse func(v *viper.Viper) error
+	}{
+		{"breaker", "breaker", map[string]interface{}{"window": "abc"}, func(v *viper.Viper) error { _, err := parseBreakerConfig(v); return err }},
+		{"cors", "cors", map[string]interface{}{"allow_origin_regexps": []string{"("}}, func(v *viper.Viper) error { _, err := parseCorsConfig(v); return err }},
+		{"mask", "mask", map[string]interface{}{"patterns": []map[string]string{{"regexp": "["}}}, func(v *viper.Viper) error { _, err := parseMaskConfig(v); return err }},
+		{"alert", "alert", map[string]interface{}{"templates": map[string]string{"panic": "{{.Title"}}, func(v *viper.Viper) error { _, err := parseAlertConfig(v); return err }},
+		{"session", "session", map[string]interface{}{"enable": true}, func(v *viper.Viper) error { _, err := parseSessionConfig(v); return err }},
+		{"maintenance", "maintenance", map[string]interface{}{"allow_ips": []string{"bad ip"}}, func(v *viper.Viper) error { _, err := parseMaintenanceConfig(v); return err }},
+		{"fault", "fault", map[string]interface{}{"inbound": []map[string]string{{"action": "boom"}}}, func(v *viper.Viper) error { _, err := parseFaultConfig(v); return err }},
+		{"canary", "canary", map[string]interface{}{"rules": []map[string]interface{}{{"ips": []string{"bad ip"}}}}, func(v *viper.Viper) error { _, err := parseCanaryConfig(v); return err }},
+	}
+	for _, tt := range tests {
+		v := viper.New()
+		v.Set(tt.key, tt.value)
+		err := tt.parse(v)
+		if err == nil {
+			t.Errorf("%s: invalid config should return error", tt.name)
+		}
+		// 热更新失败不应panic，且保留原有的配置
+		if storeConfig(tt.name, &store, nil, err) {
+			t.Errorf("%s: invalid config should not be stored", tt.name)
+		}
+		if store.Load() != conf {
+			t.Errorf("%s: previous config should be kept", tt.name)
+		}
+	}
+}
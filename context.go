@@ -1,6 +1,7 @@
 package core
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -9,15 +10,20 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.uber.org/zap"
 	"gorm.io/gorm"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 )
 
 type Context struct {
 	context.Context
-	Writer     http.ResponseWriter
+	Writer     ResponseWriter
 	Request    *http.Request
 	Path       string
+	Pattern    string //匹配到的路由规则，例如 /user/:id
 	Method     string
 	StatusCode int
 	Params     map[string]string
@@ -31,12 +37,71 @@ type Context struct {
 
 func newContext(w http.ResponseWriter, r *http.Request) *Context {
 	return &Context{
-		Writer:  w,
+		Writer:  newResponseWriter(w),
 		Request: r,
 		Path:    r.URL.Path,
 		Method:  r.Method,
 		index:   -1,
-		Context: context.Background(),
+		Context: r.Context(), // 跟随请求的生命周期，客户端断开时自动取消
+		Log:     globalLog,
+	}
+}
+
+// detachedContext 保留上下文中的数据，但不再跟随请求取消
+type detachedContext struct {
+	parent context.Context
+}
+
+func (d detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+
+func (d detachedContext) Done() <-chan struct{} { return nil }
+
+func (d detachedContext) Err() error { return nil }
+
+func (d detachedContext) Value(key interface{}) interface{} { return d.parent.Value(key) }
+
+// valueContext 取消及超时跟随 Context，数据从 values 中读取
+// 用于将超时处理协程中设置的数据带回外层，而不继承已经取消的超时上下文
+type valueContext struct {
+	context.Context
+	values context.Context
+}
+
+func (v valueContext) Value(key interface{}) interface{} { return v.values.Value(key) }
+
+// discardWriter 副本的响应输出，请求结束后写入响应没有意义，直接丢弃
+type discardWriter struct {
+	header http.Header
+}
+
+func (d *discardWriter) Header() http.Header { return d.header }
+
+func (d *discardWriter) Write(b []byte) (int, error) { return len(b), nil }
+
+func (d *discardWriter) WriteHeader(int) {}
+
+// Copy 复制一份当前请求的只读快照，用于在异步任务中使用
+// 副本保留链路ID、日志、配置以及上下文中的数据，不会随请求结束而取消，也无法再写入响应
+func (c *Context) Copy() *Context {
+	ctx := detachedContext{parent: c.Context}
+	params := make(map[string]string, len(c.Params))
+	for key, val := range c.Params {
+		params[key] = val
+	}
+	return &Context{
+		Context:    ctx,
+		Writer:     newResponseWriter(&discardWriter{header: http.Header{}}),
+		Request:    c.Request.WithContext(ctx),
+		Path:       c.Path,
+		Pattern:    c.Pattern,
+		Method:     c.Method,
+		StatusCode: c.StatusCode,
+		Params:     params,
+		index:      -1,
+		engine:     c.engine,
+		TraceID:    c.TraceID,
+		Log:        c.Log,
+		Config:     c.Config,
 	}
 }
 
@@ -44,14 +109,46 @@ func (c *Context) SrvName() string {
 	return globalServiceName
 }
 
+// ClientIP 获取客户端ip，只有直连地址为 system.trusted_proxies 中的可信代理时才读取代理头，防止伪造
+func (c *Context) ClientIP() string {
+	remote := strings.TrimSpace(c.Request.RemoteAddr)
+	if ip, _, err := net.SplitHostPort(remote); err == nil {
+		remote = ip
+	}
+	if !isTrustedProxy(remote) {
+		return remote
+	}
+	// 从右往左跳过可信代理，第一个不可信的地址即为客户端ip
+	forwarded := strings.Split(c.Request.Header.Get("X-Forwarded-For"), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if ip != "" && (i == 0 || !isTrustedProxy(ip)) {
+			return ip
+		}
+	}
+	if ip := strings.TrimSpace(c.Request.Header.Get("X-Real-Ip")); ip != "" {
+		return ip
+	}
+	return remote
+}
+
+// Mysql 获取绑定了当前请求上下文的数据库连接，请求取消或超时时查询随之中断
 func (c *Context) Mysql(db string) *gorm.DB {
-	return globalMysqlConnects[db]
+	client, ok := globalMysqlConnects[db]
+	if !ok {
+		return nil
+	}
+	return client.WithContext(c)
 }
 
+// Mongo 获取mongo连接
+// 每个操作都需要显式传入ctx，传入当前Context即可随请求取消，例如 c.Mongo("db").Database("x").Collection("y").FindOne(c, filter)
 func (c *Context) Mongo(db string) *mongo.Client {
 	return globalMongoConnects[db]
 }
 
+// Redis 获取redis连接，未启用redis时返回nil
+// 每个命令都需要显式传入ctx，传入当前Context即可随请求取消，例如 c.Redis().Get(c, key)
 func (c *Context) Redis() *redis.Client {
 	return globalRedisConnect
 }
@@ -149,10 +246,11 @@ func (c *Context) String(code int, format string, arg ...interface{}) {
 	c.Writer.Write([]byte(fmt.Sprintf(format, arg...)))
 }
 
+// HTML 渲染模板，data 为 H 时会注入 csp_nonce 及 csrf_token
 func (c *Context) HTML(code int, name string, data interface{}) {
 	c.Writer.Header().Set("Content-Type", "text/html")
 	c.Status(code)
-	if err := c.engine.htmlTemplates.ExecuteTemplate(c.Writer, name, data); err != nil {
+	if err := c.engine.htmlTemplates.ExecuteTemplate(c.Writer, name, c.templateData(data)); err != nil {
 		c.Fail(500, err.Error())
 	}
 }
@@ -163,6 +261,38 @@ func (c *Context) XML(code int, html string) {
 	c.Writer.Write([]byte(html))
 }
 
+// Body 读取请求体，读取后会重置请求体，后续仍可再次读取
+func (c *Context) Body() ([]byte, error) {
+	if c.Request.Body == nil {
+		return nil, nil
+	}
+	data, err := ioutil.ReadAll(c.Request.Body)
+	c.Request.Body.Close()
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return data, err
+}
+
+// MultipartForm 解析文件上传，内存占用受 system.max_multipart_memory 限制
+func (c *Context) MultipartForm() (*multipart.Form, error) {
+	if err := c.Request.ParseMultipartForm(globalSystemConfig.MaxMultipartMemory); err != nil {
+		return nil, err
+	}
+	return c.Request.MultipartForm, nil
+}
+
+// FormFile 获取上传的文件
+func (c *Context) FormFile(name string) (*multipart.FileHeader, error) {
+	form, err := c.MultipartForm()
+	if err != nil {
+		return nil, err
+	}
+	files := form.File[name]
+	if len(files) == 0 {
+		return nil, http.ErrMissingFile
+	}
+	return files[0], nil
+}
+
 func (c *Context) Param(key string) string {
 	return c.Params[key]
 }
@@ -193,7 +323,8 @@ func (c *Context) HttpTool() *httpTool {
 		SetTimeout(globalRequestConfig.Timeout).
 		SetHeader(TraceID, c.TraceID).
 		SetHeader("User-Agent", c.SrvName()).
-		SetHeader("Remote-Service", c.SrvName())
+		SetHeader("Remote-Service", c.SrvName()).
+		SetTransport(newBreakerTransport(newFaultTransport(client.GetClient().Transport)))
 
-	return &httpTool{ctx: c, request: client.R()}
+	return &httpTool{ctx: c, request: client.R().SetContext(c)}
 }
@@ -0,0 +1,46 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	conf := globalSystemConfig
+	defer func() { globalSystemConfig = conf }()
+	for _, item := range []string{"10.0.0.0/8", "127.0.0.1"} {
+		ipNet, _ := parseIPNet(item)
+		globalSystemConfig.trustedNets = append(globalSystemConfig.trustedNets, ipNet)
+	}
+
+	tests := []struct {
+		name      string
+		remote    string
+		forwarded string
+		realIP    string
+		want      string
+	}{
+		{"direct", "1.1.1.1:1000", "", "", "1.1.1.1"},
+		{"untrusted proxy ignores headers", "1.1.1.1:1000", "2.2.2.2", "3.3.3.3", "1.1.1.1"},
+		{"trusted proxy", "10.0.0.1:1000", "2.2.2.2", "", "2.2.2.2"},
+		{"skip trusted hops", "10.0.0.1:1000", "2.2.2.2, 10.0.0.2, 127.0.0.1", "", "2.2.2.2"},
+		{"spoofed leftmost", "10.0.0.1:1000", "9.9.9.9, 2.2.2.2", "", "2.2.2.2"},
+		{"all trusted", "10.0.0.1:1000", "10.0.0.3, 10.0.0.2", "", "10.0.0.3"},
+		{"real ip", "127.0.0.1:1000", "", "2.2.2.2", "2.2.2.2"},
+		{"trusted proxy without headers", "127.0.0.1:1000", "", "", "127.0.0.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		if tt.forwarded != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if tt.realIP != "" {
+			r.Header.Set("X-Real-Ip", tt.realIP)
+		}
+		if got := newContext(httptest.NewRecorder(), r).ClientIP(); got != tt.want {
+			t.Errorf("%s: ClientIP() = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
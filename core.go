@@ -6,10 +6,12 @@ import (
 	"net/http"
 	"path"
 	"strings"
+	"time"
 )
 
 type routerGroup struct {
 	prefix      string
+	timeout     time.Duration // 分组超时时间，为0时沿用上级配置
 	middlewares []HandlerFunc
 	parent      *routerGroup
 	engine      *engine
@@ -31,21 +33,37 @@ func (e *engine) LoadHTMLGlob(pattern string) {
 	e.htmlTemplates = template.Must(template.New("").Funcs(e.funcMap).ParseGlob(pattern))
 }
 
-func New(srvName string) *engine {
+// New 创建服务，并按配置注册默认中间件
+func New(srvName string, opts ...Option) *engine {
+	o := newOptions(opts)
+	e := newEngine(srvName, o)
+	//注册中间件
+	e.Use(o.defaultMiddlewares()...)
+	return e
+}
+
+// Bare 创建不包含任何默认中间件的服务
+func Bare(srvName string, opts ...Option) *engine {
+	return newEngine(srvName, newOptions(opts))
+}
+
+func newEngine(srvName string, o *engineOptions) *engine {
 	globalServiceName = srvName
 	// 初始化配置信息
 	initConfig()
 	// 初始化请求配置信息
-	initHttpToolConfig()
+	globalRequestConfig = *initHttpToolConfig()
 	// 初始化日志信息
 	globalLog = initLog(globalConfig, srvName)
+	// 注册告警回调
+	initAlert()
+	// 使用选项覆盖配置
+	o.apply()
 
 	// 初始化路由
 	e := &engine{router: newRouter()}
 	e.routerGroup = &routerGroup{engine: e}
 	e.groups = []*routerGroup{e.routerGroup}
-	//注册中间件
-	e.Use(timeout(), cpuLoad(), recovery(), traceLog(), ipLimit())
 	return e
 }
 
@@ -60,30 +78,53 @@ func (group *routerGroup) Group(prefix string) *routerGroup {
 	return newGroup
 }
 
-func (group *routerGroup) addRoute(method string, comp string, handler HandlerFunc) {
+// Timeout 设置分组的超时时间，覆盖全局的 system.timeout
+func (group *routerGroup) Timeout(timeout time.Duration) *routerGroup {
+	group.timeout = timeout
+	return group
+}
+
+// addRoute 注册路由，handlers 中最后一个为处理函数，之前的为该路由独有的中间件
+func (group *routerGroup) addRoute(method string, comp string, handlers []HandlerFunc) {
 	pattern := group.prefix + comp
 	log.Printf("Route %4s - %s", method, pattern)
-	group.engine.router.addRoute(method, pattern, handler)
+	group.engine.router.addRoute(method, pattern, handlers)
 }
 
 // GET defines the method to add GET request
-func (group *routerGroup) GET(pattern string, handler HandlerFunc) {
-	group.addRoute("GET", pattern, handler)
+func (group *routerGroup) GET(pattern string, handlers ...HandlerFunc) {
+	group.addRoute("GET", pattern, handlers)
 }
 
 // POST defines the method to add POST request
-func (group *routerGroup) POST(pattern string, handler HandlerFunc) {
-	group.addRoute("POST", pattern, handler)
+func (group *routerGroup) POST(pattern string, handlers ...HandlerFunc) {
+	group.addRoute("POST", pattern, handlers)
 }
 
 // PUT defines the method to add PUT request
-func (group *routerGroup) PUT(pattern string, handler HandlerFunc) {
-	group.addRoute("PUT", pattern, handler)
+func (group *routerGroup) PUT(pattern string, handlers ...HandlerFunc) {
+	group.addRoute("PUT", pattern, handlers)
 }
 
 // DELETE defines the method to add DELETE request
-func (group *routerGroup) DELETE(pattern string, handler HandlerFunc) {
-	group.addRoute("DELETE", pattern, handler)
+func (group *routerGroup) DELETE(pattern string, handlers ...HandlerFunc) {
+	group.addRoute("DELETE", pattern, handlers)
+}
+
+// PATCH defines the method to add PATCH request
+func (group *routerGroup) PATCH(pattern string, handlers ...HandlerFunc) {
+	group.addRoute("PATCH", pattern, handlers)
+}
+
+// HEAD defines the method to add HEAD request
+func (group *routerGroup) HEAD(pattern string, handlers ...HandlerFunc) {
+	group.addRoute("HEAD", pattern, handlers)
+}
+
+// OPTIONS defines the method to add OPTIONS request
+// 未注册时路由会自动响应该路径支持的请求方法
+func (group *routerGroup) OPTIONS(pattern string, handlers ...HandlerFunc) {
+	group.addRoute("OPTIONS", pattern, handlers)
 }
 
 func (e *engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -100,7 +141,14 @@ func (e *engine) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (e *engine) Run(port string) error {
-	return http.ListenAndServe(port, e)
+	server := &http.Server{
+		Addr:              port,
+		Handler:           e,
+		ReadTimeout:       globalSystemConfig.ReadTimeout,
+		ReadHeaderTimeout: globalSystemConfig.ReadHeaderTimeout,
+		MaxHeaderBytes:    globalSystemConfig.MaxHeaderBytes,
+	}
+	return server.ListenAndServe()
 }
 
 func (group *routerGroup) Use(middlewares ...HandlerFunc) *engine {
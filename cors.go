@@ -0,0 +1,147 @@
+package core
+
+import (
+	"errors"
+	"github.com/spf13/viper"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type corsConfig struct {
+	Enable             bool          `json:"enable" mapstructure:"enable"`
+	AllowOrigins       []string      `json:"allow_origins" mapstructure:"allow_origins"`               //允许的来源，支持 * 以及 https://*.example.com 形式的子域名通配，* 不能与 allow_credentials 同时使用
+	AllowOriginRegexps []string      `json:"allow_origin_regexps" mapstructure:"allow_origin_regexps"` //使用正则匹配允许的来源
+	AllowMethods       []string      `json:"allow_methods" mapstructure:"allow_methods"`               //允许的请求方法，为空时使用路由注册的方法
+	AllowHeaders       []string      `json:"allow_headers" mapstructure:"allow_headers"`               //允许的请求头，为空时允许预检请求中声明的请求头
+	ExposeHeaders      []string      `json:"expose_headers" mapstructure:"expose_headers"`             //允许浏览器读取的响应头
+	AllowCredentials   bool          `json:"allow_credentials" mapstructure:"allow_credentials"`       //是否允许携带cookie
+	MaxAge             time.Duration `json:"max_age" mapstructure:"max_age"`                           //预检请求的缓存时间
+
+	allowAll bool
+	regexps  []*regexp.Regexp
+}
+
+var corsConf atomic.Value
+
+func initCorsConfig(v *viper.Viper) {
+	conf, err := parseCorsConfig(v)
+	storeConfig("cors", &corsConf, conf, err)
+}
+
+func parseCorsConfig(v *viper.Viper) (*corsConfig, error) {
+	conf := corsConfig{}
+	if err := v.UnmarshalKey("cors", &conf); err != nil {
+		return nil, err
+	}
+	for _, item := range conf.AllowOrigins {
+		if item == "*" {
+			conf.allowAll = true
+		}
+	}
+	// 任意来源携带cookie等同于关闭同源策略，浏览器也会拒绝 * 与 credentials 同时出现
+	if conf.allowAll && conf.AllowCredentials {
+		return nil, errors.New("allow_origins * can not be used with allow_credentials")
+	}
+	for _, item := range conf.AllowOriginRegexps {
+		re, err := regexp.Compile(item)
+		if err != nil {
+			return nil, err
+		}
+		conf.regexps = append(conf.regexps, re)
+	}
+	if conf.MaxAge == 0 {
+		conf.MaxAge = 12 * time.Hour
+	}
+	return &conf, nil
+}
+
+func loadCorsConfig() *corsConfig {
+	conf, _ := corsConf.Load().(*corsConfig)
+	return conf
+}
+
+// allowOrigin 判断来源是否被允许
+func (c *corsConfig) allowOrigin(origin string) bool {
+	if c.allowAll {
+		return true
+	}
+	for _, item := range c.AllowOrigins {
+		if strings.EqualFold(item, origin) {
+			return true
+		}
+		// https://*.example.com 匹配 https://a.example.com，不匹配 https://example.com
+		if index := strings.Index(item, "*."); index != -1 {
+			prefix, suffix := item[:index], item[index+1:]
+			if len(origin) > len(prefix)+len(suffix) &&
+				strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) {
+				return true
+			}
+		}
+	}
+	for _, item := range c.regexps {
+		if item.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// Cors 跨域处理，配置支持热更新
+func Cors() HandlerFunc {
+	return func(ctx *Context) {
+		conf := loadCorsConfig()
+		if conf == nil || !conf.Enable {
+			return
+		}
+		origin := ctx.Request.Header.Get("Origin")
+		if origin == "" {
+			return
+		}
+
+		ctx.AddHeader("Vary", "Origin")
+		preflight := ctx.Method == http.MethodOptions && ctx.Request.Header.Get("Access-Control-Request-Method") != ""
+		if !conf.allowOrigin(origin) {
+			if preflight {
+				ctx.Fail(http.StatusForbidden, "origin not allowed")
+			}
+			return
+		}
+
+		if conf.allowAll {
+			ctx.SetHeader("Access-Control-Allow-Origin", "*")
+		} else {
+			ctx.SetHeader("Access-Control-Allow-Origin", origin)
+		}
+		if conf.AllowCredentials {
+			ctx.SetHeader("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if len(conf.ExposeHeaders) != 0 {
+				ctx.SetHeader("Access-Control-Expose-Headers", strings.Join(conf.ExposeHeaders, ", "))
+			}
+			return
+		}
+
+		// 预检请求直接响应，不再进入路由处理
+		ctx.AddHeader("Vary", "Access-Control-Request-Method")
+		ctx.AddHeader("Vary", "Access-Control-Request-Headers")
+		methods := conf.AllowMethods
+		if len(methods) == 0 && ctx.engine != nil {
+			methods = ctx.engine.router.allowed(ctx.Path)
+		}
+		ctx.SetHeader("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(conf.AllowHeaders) != 0 {
+			ctx.SetHeader("Access-Control-Allow-Headers", strings.Join(conf.AllowHeaders, ", "))
+		} else if headers := ctx.Request.Header.Get("Access-Control-Request-Headers"); headers != "" {
+			ctx.SetHeader("Access-Control-Allow-Headers", headers)
+		}
+		ctx.SetHeader("Access-Control-Max-Age", strconv.FormatInt(int64(conf.MaxAge.Seconds()), 10))
+		ctx.Status(http.StatusNoContent)
+		ctx.Abort()
+	}
+}
@@ -0,0 +1,48 @@
+package core
+
+import (
+	"github.com/spf13/viper"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCorsConfig(t *testing.T) {
+	v := viper.New()
+	v.Set("cors", map[string]interface{}{"allow_origins": []string{"*"}, "allow_credentials": true})
+	if _, err := parseCorsConfig(v); err == nil {
+		t.Fatal("allow_origins * with allow_credentials should be rejected")
+	}
+}
+
+func TestCors(t *testing.T) {
+	tests := []struct {
+		name        string
+		conf        corsConfig
+		origin      string
+		allowOrigin string
+		credentials string
+	}{
+		{"allow all", corsConfig{Enable: true, allowAll: true}, "https://evil.com", "*", ""},
+		{"exact origin with credentials", corsConfig{Enable: true, AllowOrigins: []string{"https://a.example.com"}, AllowCredentials: true}, "https://a.example.com", "https://a.example.com", "true"},
+		{"wildcard subdomain", corsConfig{Enable: true, AllowOrigins: []string{"https://*.example.com"}}, "https://b.example.com", "https://b.example.com", ""},
+		{"not allowed", corsConfig{Enable: true, AllowOrigins: []string{"https://*.example.com"}, AllowCredentials: true}, "https://example.com.evil.com", "", ""},
+	}
+	for _, tt := range tests {
+		conf := tt.conf
+		corsConf.Store(&conf)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("Origin", tt.origin)
+		ctx := newContext(w, r)
+		ctx.handlers = []HandlerFunc{Cors()}
+		ctx.Next()
+		if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+			t.Errorf("%s: Access-Control-Allow-Origin = %q, want %q", tt.name, got, tt.allowOrigin)
+		}
+		if got := w.Header().Get("Access-Control-Allow-Credentials"); got != tt.credentials {
+			t.Errorf("%s: Access-Control-Allow-Credentials = %q, want %q", tt.name, got, tt.credentials)
+		}
+	}
+	corsConf.Store(&corsConfig{})
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// CSRFTokenKey 当前请求的CSRF token在上下文及模板数据中的key
+var CSRFTokenKey = "csrf_token"
+
+type csrfConfig struct {
+	Enable         bool          `json:"enable" mapstructure:"enable"`
+	Mode           string        `json:"mode" mapstructure:"mode"`                         //double_submit 双重提交cookie，synchronizer 同步令牌，令牌保存在redis
+	Secret         string        `json:"secret" mapstructure:"secret"`                     //double_submit 模式下对令牌签名，防止子域名写入伪造的cookie
+	Prefix         string        `json:"prefix" mapstructure:"prefix"`                     //synchronizer 模式下令牌在redis中的key前缀
+	Expire         time.Duration `json:"expire" mapstructure:"expire"`                     //令牌有效期
+	HeaderName     string        `json:"header_name" mapstructure:"header_name"`           //提交令牌的请求头
+	FormField      string        `json:"form_field" mapstructure:"form_field"`             //提交令牌的表单字段
+	CookieName     string        `json:"cookie_name" mapstructure:"cookie_name"`           //double_submit 模式下保存令牌，synchronizer 模式下保存会话ID
+	CookiePath     string        `json:"cookie_path" mapstructure:"cookie_path"`           //cookie路径
+	CookieDomain   string        `json:"cookie_domain" mapstructure:"cookie_domain"`       //cookie域名
+	CookieSecure   bool          `json:"cookie_secure" mapstructure:"cookie_secure"`       //仅https发送cookie
+	CookieSameSite string        `json:"cookie_same_site" mapstructure:"cookie_same_site"` //lax/strict/none
+	SkipRoutes     []routeRule   `json:"skip_routes" mapstructure:"skip_routes"`           //不校验的路由，例如使用jwt鉴权的接口
+}
+
+var csrfConf atomic.Value
+
+func initCsrfConfig(v *viper.Viper) {
+	conf, err := parseCsrfConfig(v)
+	storeConfig("csrf", &csrfConf, conf, err)
+}
+
+func parseCsrfConfig(v *viper.Viper) (*csrfConfig, error) {
+	conf := csrfConfig{}
+	if err := v.UnmarshalKey("csrf", &conf); err != nil {
+		return nil, err
+	}
+	if conf.Mode == "" {
+		conf.Mode = "double_submit"
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = "csrf"
+	}
+	if conf.Expire == 0 {
+		conf.Expire = 12 * time.Hour
+	}
+	if conf.HeaderName == "" {
+		conf.HeaderName = "X-CSRF-Token"
+	}
+	if conf.FormField == "" {
+		conf.FormField = "csrf_token"
+	}
+	if conf.CookieName == "" {
+		conf.CookieName = "_csrf"
+	}
+	if conf.CookiePath == "" {
+		conf.CookiePath = "/"
+	}
+	return &conf, nil
+}
+
+func loadCsrfConfig() *csrfConfig {
+	conf, _ := csrfConf.Load().(*csrfConfig)
+	if conf == nil {
+		return &csrfConfig{}
+	}
+	return conf
+}
+
+// CSRFToken 获取当前请求的CSRF令牌，用于输出到页面表单或请求头
+func (c *Context) CSRFToken() string {
+	return c.GetString(CSRFTokenKey)
+}
+
+// parseSameSite 解析cookie的SameSite配置
+func parseSameSite(mode string) http.SameSite {
+	switch strings.ToLower(mode) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	case "lax":
+		return http.SameSiteLaxMode
+	}
+	return http.SameSiteDefaultMode
+}
+
+func (c *csrfConfig) setCookie(ctx *Context, value string, httpOnly bool) {
+	http.SetCookie(ctx.Writer, &http.Cookie{
+		Name:     c.CookieName,
+		Value:    value,
+		Path:     c.CookiePath,
+		Domain:   c.CookieDomain,
+		MaxAge:   int(c.Expire / time.Second),
+		Secure:   c.CookieSecure,
+		HttpOnly: httpOnly,
+		SameSite: parseSameSite(c.CookieSameSite),
+	})
+}
+
+// signToken 配置了密钥时令牌格式为 随机串.签名
+func (c *csrfConfig) signToken(random string) string {
+	if c.Secret == "" {
+		return random
+	}
+	mac := hmac.New(sha256.New, []byte(c.Secret))
+	mac.Write([]byte(random))
+	return random + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (c *csrfConfig) validToken(token string) bool {
+	if token == "" {
+		return false
+	}
+	random := token
+	if c.Secret != "" {
+		index := strings.LastIndexByte(token, '.')
+		if index <= 0 {
+			return false
+		}
+		random = token[:index]
+	}
+	return hmac.Equal([]byte(c.signToken(random)), []byte(token))
+}
+
+// submittedToken 从请求头或表单中获取提交的令牌
+func (c *csrfConfig) submittedToken(ctx *Context) string {
+	if token := ctx.Request.Header.Get(c.HeaderName); token != "" {
+		return token
+	}
+	if strings.HasPrefix(ctx.Request.Header.Get("Content-Type"), "multipart/form-data") {
+		if _, err := ctx.MultipartForm(); err != nil {
+			return ""
+		}
+	}
+	return ctx.Request.PostFormValue(c.FormField)
+}
+
+// doubleSubmitToken cookie中的令牌，不存在或无效时生成新的令牌
+func (c *csrfConfig) doubleSubmitToken(ctx *Context, create bool) string {
+	if cookie, err := ctx.Request.Cookie(c.CookieName); err == nil && c.validToken(cookie.Value) {
+		return cookie.Value
+	}
+	if !create {
+		return ""
+	}
+	token := c.signToken(randomToken(32))
+	// 前端需要读取cookie并通过请求头提交，不设置HttpOnly
+	c.setCookie(ctx, token, false)
+	return token
+}
+
+// synchronizerToken 根据cookie中的会话ID从redis获取令牌，不存在时生成新的令牌
+func (c *csrfConfig) synchronizerToken(ctx *Context, create bool) (string, error) {
+	client := ctx.Redis()
+	if client == nil {
+		return "", errors.New("redis is not enabled")
+	}
+	sid := ""
+	if cookie, err := ctx.Request.Cookie(c.CookieName); err == nil {
+		sid = cookie.Value
+		token, err := client.Get(ctx, c.Prefix+":"+sid).Result()
+		if err == nil {
+			return token, nil
+		}
+		if err != redis.Nil {
+			return "", err
+		}
+	}
+	if !create {
+		return "", nil
+	}
+	if sid == "" {
+		sid = randomToken(32)
+		c.setCookie(ctx, sid, true)
+	}
+	token := randomToken(32)
+	if err := client.Set(ctx, c.Prefix+":"+sid, token, c.Expire).Err(); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// CSRF 跨站请求伪造防护，安全方法下发令牌，其余方法校验提交的令牌
+func CSRF() HandlerFunc {
+	return func(ctx *Context) {
+		conf := loadCsrfConfig()
+		if !conf.Enable {
+			return
+		}
+		for _, item := range conf.SkipRoutes {
+			if item.match(ctx) {
+				return
+			}
+		}
+
+		safe := false
+		switch ctx.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+			safe = true
+		}
+
+		var token string
+		if conf.Mode == "synchronizer" {
+			var err error
+			token, err = conf.synchronizerToken(ctx, safe)
+			if err != nil {
+				ctx.Log.Error("csrf token load fail", zap.Error(err))
+				ctx.Fail(http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+		} else {
+			token = conf.doubleSubmitToken(ctx, safe)
+		}
+
+		if !safe {
+			submitted := conf.submittedToken(ctx)
+			if token == "" || !hmac.Equal([]byte(token), []byte(submitted)) {
+				ctx.Fail(http.StatusForbidden, "csrf token is invalid")
+				return
+			}
+		}
+		ctx.SetValue(CSRFTokenKey, token)
+	}
+}
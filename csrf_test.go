@@ -0,0 +1,106 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serveCsrf 执行CSRF中间件，返回响应及下发的令牌
+func serveCsrf(r *http.Request) (*httptest.ResponseRecorder, string) {
+	w := httptest.NewRecorder()
+	ctx := newContext(w, r)
+	var token string
+	ctx.handlers = []HandlerFunc{CSRF(), func(ctx *Context) {
+		token = ctx.CSRFToken()
+		ctx.String(http.StatusOK, "ok")
+	}}
+	ctx.Next()
+	return w, token
+}
+
+func TestCSRFDoubleSubmit(t *testing.T) {
+	csrfConf.Store(&csrfConfig{Enable: true, Mode: "double_submit", Secret: "secret", HeaderName: "X-CSRF-Token", FormField: "csrf_token", CookieName: "_csrf", CookiePath: "/",
+		SkipRoutes: []routeRule{{Method: "POST", Path: "/api/*"}}})
+	defer csrfConf.Store(&csrfConfig{})
+
+	w, token := serveCsrf(httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := w.Result().Cookies()
+	if token == "" || len(cookies) != 1 || cookies[0].Value != token || cookies[0].HttpOnly {
+		t.Fatalf("safe request should issue readable token cookie, got %q %v", token, cookies)
+	}
+	// 已有有效令牌时不重新下发
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	if w, got := serveCsrf(r); got != token || len(w.Result().Cookies()) != 0 {
+		t.Fatal("valid cookie token should be reused")
+	}
+
+	forged := "random.forged"
+	tests := []struct {
+		name   string
+		path   string
+		cookie string
+		header string
+		form   string
+		status int
+	}{
+		{"header", "/order", token, token, "", http.StatusOK},
+		{"form field", "/order", token, "", token, http.StatusOK},
+		{"missing token", "/order", token, "", "", http.StatusForbidden},
+		{"mismatch", "/order", token, token + "x", "", http.StatusForbidden},
+		{"missing cookie", "/order", "", token, "", http.StatusForbidden},
+		{"forged cookie", "/order", forged, forged, "", http.StatusForbidden},
+		{"skip route", "/api/order", "", "", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		var r *http.Request
+		if tt.form != "" {
+			r = httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(url.Values{"csrf_token": {tt.form}}.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		} else {
+			r = httptest.NewRequest(http.MethodPost, tt.path, nil)
+		}
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "_csrf", Value: tt.cookie})
+		}
+		if tt.header != "" {
+			r.Header.Set("X-CSRF-Token", tt.header)
+		}
+		if w, _ := serveCsrf(r); w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
+
+func TestCSRFSynchronizer(t *testing.T) {
+	useTestRedis(t)
+	csrfConf.Store(&csrfConfig{Enable: true, Mode: "synchronizer", Prefix: "csrf", Expire: time.Hour, HeaderName: "X-CSRF-Token", FormField: "csrf_token", CookieName: "_csrf", CookiePath: "/"})
+	defer csrfConf.Store(&csrfConfig{})
+
+	w, token := serveCsrf(httptest.NewRequest(http.MethodGet, "/", nil))
+	cookies := w.Result().Cookies()
+	if token == "" || len(cookies) != 1 || cookies[0].Value == token || !cookies[0].HttpOnly {
+		t.Fatalf("synchronizer should store session id in http only cookie, got %q %v", token, cookies)
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		status int
+	}{
+		{"valid", token, http.StatusOK},
+		{"invalid", "other", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.AddCookie(cookies[0])
+		r.Header.Set("X-CSRF-Token", tt.header)
+		if w, _ := serveCsrf(r); w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
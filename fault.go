@@ -0,0 +1,193 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 故障类型
+const (
+	FaultDelay = "delay" // 延迟后继续处理
+	FaultAbort = "abort" // 直接返回指定的状态码
+	FaultDrop  = "drop"  // 断开连接，不返回响应
+)
+
+// ErrFaultDrop 出口请求被注入断开连接的故障
+var ErrFaultDrop = errors.New("fault injection: connection dropped")
+
+type faultRule struct {
+	routeRule  `mapstructure:",squash"`
+	Host       string            `json:"host" mapstructure:"host"`             //出口请求的目标host，为空时匹配所有host
+	Headers    map[string]string `json:"headers" mapstructure:"headers"`       //需要匹配的请求头，值为空时只要求请求头存在
+	Percentage float64           `json:"percentage" mapstructure:"percentage"` //注入的概率，0-100
+	Action     string            `json:"action" mapstructure:"action"`         //delay/abort/drop
+	Delay      time.Duration     `json:"delay" mapstructure:"delay"`           //delay 的延迟时间
+	Status     int               `json:"status" mapstructure:"status"`         //abort 返回的状态码，默认503
+	Message    string            `json:"message" mapstructure:"message"`       //abort 返回的错误信息
+}
+
+type faultConfig struct {
+	Enable   bool        `json:"enable" mapstructure:"enable"`
+	Inbound  []faultRule `json:"inbound" mapstructure:"inbound"`   //入口请求的故障规则，按路由匹配
+	Outbound []faultRule `json:"outbound" mapstructure:"outbound"` //httpTool 出口请求的故障规则，按目标host及路径匹配
+}
+
+var faultConf atomic.Value
+
+func initFaultConfig(v *viper.Viper) {
+	conf, err := parseFaultConfig(v)
+	storeConfig("fault", &faultConf, conf, err)
+}
+
+func parseFaultConfig(v *viper.Viper) (*faultConfig, error) {
+	conf := faultConfig{}
+	if err := v.UnmarshalKey("fault", &conf); err != nil {
+		return nil, err
+	}
+	for _, rules := range [][]faultRule{conf.Inbound, conf.Outbound} {
+		for i := range rules {
+			switch rules[i].Action {
+			case FaultDelay, FaultAbort, FaultDrop:
+			default:
+				return nil, errors.New("unsupported fault action: " + rules[i].Action)
+			}
+			if rules[i].Status == 0 {
+				rules[i].Status = http.StatusServiceUnavailable
+			}
+			if rules[i].Message == "" {
+				rules[i].Message = "fault injected"
+			}
+		}
+	}
+	return &conf, nil
+}
+
+// loadFaultConfig 只有明确配置为开发或测试环境时才生效，其余环境始终返回关闭的配置
+func loadFaultConfig() *faultConfig {
+	conf, _ := faultConf.Load().(*faultConfig)
+	if conf == nil || !isDebugEnv() {
+		return &faultConfig{}
+	}
+	return conf
+}
+
+// matchHeader 请求头是否满足规则
+func (r *faultRule) matchHeader(header http.Header) bool {
+	for name, val := range r.Headers {
+		got := header.Get(name)
+		if got == "" || (val != "" && got != val) {
+			return false
+		}
+	}
+	return true
+}
+
+// hit 按概率决定是否注入
+func (r *faultRule) hit() bool {
+	return r.Percentage > 0 && rand.Float64()*100 < r.Percentage
+}
+
+// sleep 延迟指定时间，请求取消时提前返回
+func (r *faultRule) sleep(done <-chan struct{}) {
+	timer := time.NewTimer(r.Delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-done:
+	}
+}
+
+// Fault 入口故障注入，用于混沌测试验证超时、熔断、重试等配置，仅在 dev/test 环境生效
+func Fault() HandlerFunc {
+	return func(ctx *Context) {
+		conf := loadFaultConfig()
+		if !conf.Enable {
+			return
+		}
+		var rule *faultRule
+		for i := range conf.Inbound {
+			if conf.Inbound[i].match(ctx) && conf.Inbound[i].matchHeader(ctx.Request.Header) {
+				rule = &conf.Inbound[i]
+				break
+			}
+		}
+		if rule == nil || !rule.hit() {
+			return
+		}
+		switch rule.Action {
+		case FaultDelay:
+			rule.sleep(ctx.Done())
+		case FaultAbort:
+			ctx.SetHeader("X-Fault-Injected", FaultAbort)
+			ctx.Fail(rule.Status, rule.Message)
+		case FaultDrop:
+			// 由http.Server直接关闭连接，recovery不会处理
+			panic(http.ErrAbortHandler)
+		}
+	}
+}
+
+// faultTransport 出口故障注入，位于熔断之内，注入的故障同样计入熔断统计
+type faultTransport struct {
+	next http.RoundTripper
+}
+
+func newFaultTransport(next http.RoundTripper) http.RoundTripper {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &faultTransport{next: next}
+}
+
+func (t *faultTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	conf := loadFaultConfig()
+	if !conf.Enable {
+		return t.next.RoundTrip(req)
+	}
+	var rule *faultRule
+	for i := range conf.Outbound {
+		item := &conf.Outbound[i]
+		if (item.Host == "" || strings.EqualFold(item.Host, req.URL.Host)) &&
+			item.matchRequest(req.Method, req.URL.Path) && item.matchHeader(req.Header) {
+			rule = item
+			break
+		}
+	}
+	if rule == nil || !rule.hit() {
+		return t.next.RoundTrip(req)
+	}
+	if rule.Action == FaultDelay {
+		rule.sleep(req.Context().Done())
+		if err := req.Context().Err(); err != nil {
+			return nil, err
+		}
+		return t.next.RoundTrip(req)
+	}
+	// 不发送请求时需要关闭请求体
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	if rule.Action == FaultAbort {
+		body := []byte(rule.Message)
+		return &http.Response{
+			Status:        strconv.Itoa(rule.Status) + " " + http.StatusText(rule.Status),
+			StatusCode:    rule.Status,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        http.Header{"Content-Type": {"text/plain"}, "X-Fault-Injected": {FaultAbort}},
+			Body:          ioutil.NopCloser(bytes.NewReader(body)),
+			ContentLength: int64(len(body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, ErrFaultDrop
+}
@@ -0,0 +1,140 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestFaultEnv(t *testing.T) {
+	env := globalSystemConfig.Env
+	defer func() { globalSystemConfig.Env = env }()
+	faultConf.Store(&faultConfig{Enable: true})
+	defer faultConf.Store(&faultConfig{})
+
+	tests := []struct {
+		env    string
+		enable bool
+	}{
+		{"dev", true},
+		{"Test", true},
+		{"development", true},
+		{"", false},
+		{"prod", false},
+		{"staging", false},
+	}
+	for _, tt := range tests {
+		globalSystemConfig.Env = tt.env
+		if got := loadFaultConfig().Enable; got != tt.enable {
+			t.Errorf("env %q: enable = %v, want %v", tt.env, got, tt.enable)
+		}
+	}
+}
+
+func TestFault(t *testing.T) {
+	env := globalSystemConfig.Env
+	globalSystemConfig.Env = "test"
+	defer func() { globalSystemConfig.Env = env }()
+
+	v := viper.New()
+	v.Set("fault", map[string]interface{}{
+		"enable": true,
+		"inbound": []map[string]interface{}{
+			{"path": "/abort", "action": "abort", "percentage": 100, "status": 500, "message": "injected"},
+			{"path": "/delay", "action": "delay", "percentage": 100, "delay": "30ms"},
+			{"path": "/drop", "action": "drop", "percentage": 100},
+			{"path": "/header", "action": "abort", "percentage": 100, "headers": map[string]string{"X-Chaos": ""}},
+			{"path": "/never", "action": "abort", "percentage": 0},
+		},
+		"outbound": []map[string]interface{}{
+			{"host": "api.example.com", "path": "/abort", "action": "abort", "percentage": 100, "status": 502},
+			{"path": "/drop", "action": "drop", "percentage": 100},
+		},
+	})
+	initFaultConfig(v)
+	defer faultConf.Store(&faultConfig{})
+
+	tests := []struct {
+		name   string
+		path   string
+		header string
+		status int
+		delay  time.Duration
+		drop   bool
+	}{
+		{"abort", "/abort", "", http.StatusInternalServerError, 0, false},
+		{"delay", "/delay", "", http.StatusOK, 30 * time.Millisecond, false},
+		{"drop", "/drop", "", 0, 0, true},
+		{"header present", "/header", "1", http.StatusServiceUnavailable, 0, false},
+		{"header missing", "/header", "", http.StatusOK, 0, false},
+		{"zero percentage", "/never", "", http.StatusOK, 0, false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.header != "" {
+			r.Header.Set("X-Chaos", tt.header)
+		}
+		ctx := newContext(w, r)
+		ctx.handlers = []HandlerFunc{Fault(), func(ctx *Context) { ctx.String(http.StatusOK, "ok") }}
+		start := time.Now()
+		dropped := func() (dropped bool) {
+			defer func() {
+				dropped = recover() == http.ErrAbortHandler
+			}()
+			ctx.Next()
+			return false
+		}()
+		if dropped != tt.drop {
+			t.Errorf("%s: dropped = %v, want %v", tt.name, dropped, tt.drop)
+			continue
+		}
+		if !tt.drop && w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if time.Since(start) < tt.delay {
+			t.Errorf("%s: should be delayed %s", tt.name, tt.delay)
+		}
+	}
+
+	sent := 0
+	client := &http.Client{Transport: newFaultTransport(roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		sent++
+		return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody, Request: req}, nil
+	}))}
+	outbound := []struct {
+		name   string
+		url    string
+		status int
+		err    bool
+		sent   int
+	}{
+		{"abort", "http://api.example.com/abort", http.StatusBadGateway, false, 0},
+		{"other host", "http://other.example.com/abort", http.StatusOK, false, 1},
+		{"drop", "http://other.example.com/drop", 0, true, 1},
+	}
+	for _, tt := range outbound {
+		resp, err := client.Get(tt.url)
+		if (err != nil) != tt.err {
+			t.Errorf("%s: err = %v", tt.name, err)
+		}
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != tt.status {
+				t.Errorf("%s: status = %d, want %d", tt.name, resp.StatusCode, tt.status)
+			}
+		}
+		if sent != tt.sent {
+			t.Errorf("%s: sent %d requests, want %d", tt.name, sent, tt.sent)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"context"
+	"go.uber.org/zap"
+)
+
+// goroutineLimit 异步任务并发上限，未配置时不限制
+var goroutineLimit chan struct{}
+
+func initGoroutineLimit(max int) {
+	if max <= 0 {
+		goroutineLimit = nil
+		return
+	}
+	goroutineLimit = make(chan struct{}, max)
+}
+
+// Go 安全的启动异步任务，任务拿到的是当前请求的只读副本，
+// 请求结束后依旧可以使用链路ID、日志以及上下文中的数据，任务中的panic会被捕获并记录
+// 配置了 max_goroutine 时先获取名额再启动协程，名额已满时阻塞调用方，
+// 请求结束时仍未获取到名额则放弃任务并返回请求的错误
+func Go(ctx *Context, fn func(ctx *Context)) error {
+	var c *Context
+	var done <-chan struct{}
+	if ctx == nil {
+		c = &Context{Context: context.Background(), Log: globalLog, index: -1}
+	} else {
+		c = ctx.Copy()
+		done = ctx.Done()
+	}
+
+	limit := goroutineLimit
+	if limit != nil {
+		select {
+		case limit <- struct{}{}:
+		case <-done:
+			c.Log.Warn("goroutine dropped", zap.Error(ctx.Err()))
+			return ctx.Err()
+		}
+	}
+	go func() {
+		if limit != nil {
+			defer func() { <-limit }()
+		}
+		defer func() {
+			if err := recover(); err != nil {
+				info := newPanicInfo(c, err)
+				c.Log.Named("panic").Error(info.Message, info.fields()...)
+				notifyPanic(info)
+			}
+		}()
+		fn(c)
+	}()
+	return nil
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"context"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCopy(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	r := httptest.NewRequest("GET", "/user/1", nil).WithContext(parent)
+	w := httptest.NewRecorder()
+	ctx := newContext(w, r)
+	ctx.TraceID = "trace"
+	ctx.Params = map[string]string{"id": "1"}
+	ctx.SetValue(UserIDKey, "7")
+
+	c := ctx.Copy()
+	cancel()
+	if ctx.Err() == nil || c.Err() != nil || c.Done() != nil {
+		t.Fatal("copy should not be canceled with the request")
+	}
+	if c.TraceID != "trace" || c.GetString(UserIDKey) != "7" || c.Param("id") != "1" {
+		t.Fatal("copy lost request data")
+	}
+	c.Params["id"] = "2"
+	c.String(200, "copy")
+	if ctx.Param("id") != "1" || w.Body.Len() != 0 {
+		t.Fatal("copy should not modify the request")
+	}
+}
+
+func TestGoLimit(t *testing.T) {
+	initGoroutineLimit(2)
+	defer initGoroutineLimit(0)
+
+	var running, peak int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	task := func(*Context) {
+		defer wg.Done()
+		n := atomic.AddInt32(&running, 1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		<-release
+		atomic.AddInt32(&running, -1)
+	}
+	wg.Add(2)
+	for i := 0; i < 2; i++ {
+		if err := Go(nil, task); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 名额已满时请求结束，任务被放弃
+	parent, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	ctx := newContext(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil).WithContext(parent))
+	if err := Go(ctx, func(*Context) { t.Error("dropped task should not run") }); err != context.DeadlineExceeded {
+		t.Fatalf("expected task to be dropped, got %v", err)
+	}
+
+	// 名额已满时阻塞调用方，释放后继续启动
+	wg.Add(1)
+	started := make(chan struct{})
+	go func() {
+		Go(nil, task)
+		close(started)
+	}()
+	select {
+	case <-started:
+		t.Fatal("Go should block until a slot is free")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	<-started
+	wg.Wait()
+	if peak > 2 {
+		t.Fatalf("running goroutines exceed limit: %d", peak)
+	}
+}
@@ -0,0 +1,249 @@
+package core
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+type idempotencyRoute struct {
+	routeRule `mapstructure:",squash"`
+	TTL       time.Duration `json:"ttl" mapstructure:"ttl"`           //响应结果的保存时间
+	LockTTL   time.Duration `json:"lock_ttl" mapstructure:"lock_ttl"` //处理中锁的有效期，应大于接口的最长处理时间
+	Wait      time.Duration `json:"wait" mapstructure:"wait"`         //重复请求等待首个请求完成的时间，0为直接返回409
+	Required  bool          `json:"required" mapstructure:"required"` //是否必须携带幂等键
+}
+
+type idempotencyConfig struct {
+	Enable bool               `json:"enable" mapstructure:"enable"`
+	Header string             `json:"header" mapstructure:"header"` //幂等键所在的请求头
+	Prefix string             `json:"prefix" mapstructure:"prefix"` //redis中的key前缀
+	Routes []idempotencyRoute `json:"routes" mapstructure:"routes"`
+}
+
+// idempotencyRecord 保存的响应结果
+type idempotencyRecord struct {
+	Fingerprint string      `json:"fingerprint"` //请求内容的摘要，同一个幂等键的请求内容必须一致
+	Status      int         `json:"status"`
+	Header      http.Header `json:"header"`
+	Body        []byte      `json:"body"`
+}
+
+var idempotencyConf atomic.Value
+
+func initIdempotencyConfig(v *viper.Viper) {
+	conf, err := parseIdempotencyConfig(v)
+	storeConfig("idempotency", &idempotencyConf, conf, err)
+}
+
+func parseIdempotencyConfig(v *viper.Viper) (idempotencyConfig, error) {
+	conf := idempotencyConfig{}
+	if err := v.UnmarshalKey("idempotency", &conf); err != nil {
+		return idempotencyConfig{}, err
+	}
+	if conf.Header == "" {
+		conf.Header = "Idempotency-Key"
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = "idempotency"
+	}
+	for i := range conf.Routes {
+		if conf.Routes[i].TTL == 0 {
+			conf.Routes[i].TTL = 24 * time.Hour
+		}
+		if conf.Routes[i].LockTTL == 0 {
+			conf.Routes[i].LockTTL = 30 * time.Second
+		}
+	}
+	return conf, nil
+}
+
+func loadIdempotencyConfig() idempotencyConfig {
+	conf, _ := idempotencyConf.Load().(idempotencyConfig)
+	return conf
+}
+
+// unlockScript 只释放自己持有的锁
+var unlockScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// idempotencyWriter 在输出响应的同时保存完整的响应体
+type idempotencyWriter struct {
+	ResponseWriter
+	body []byte
+}
+
+func (w *idempotencyWriter) Write(b []byte) (int, error) {
+	w.body = append(w.body, b...)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// requestFingerprint 请求方法、路径、查询参数及请求体的摘要
+func requestFingerprint(ctx *Context) (string, error) {
+	body, err := ctx.Body()
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	hash.Write([]byte(ctx.Method + "\n" + ctx.Path + "\n" + ctx.Request.URL.RawQuery + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// replay 输出保存的响应结果
+func (r *idempotencyRecord) replay(ctx *Context) {
+	header := ctx.Writer.Header()
+	for key, val := range r.Header {
+		header[key] = val
+	}
+	header.Set("Idempotent-Replayed", "true")
+	ctx.Status(r.Status)
+	ctx.Writer.Write(r.Body)
+	ctx.Abort()
+}
+
+func loadIdempotencyRecord(ctx context.Context, client *redis.Client, key string) (*idempotencyRecord, error) {
+	data, err := client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	record := &idempotencyRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// Idempotency 幂等处理，相同幂等键的请求只处理一次，之后重放首次的响应结果
+func Idempotency() HandlerFunc {
+	return func(ctx *Context) {
+		conf := loadIdempotencyConfig()
+		if !conf.Enable {
+			return
+		}
+		var route *idempotencyRoute
+		for i := range conf.Routes {
+			if conf.Routes[i].match(ctx) {
+				route = &conf.Routes[i]
+				break
+			}
+		}
+		if route == nil {
+			return
+		}
+		idempotencyKey := strings.TrimSpace(ctx.Request.Header.Get(conf.Header))
+		if idempotencyKey == "" {
+			if route.Required {
+				ctx.Fail(http.StatusBadRequest, conf.Header+" is missing")
+			}
+			return
+		}
+
+		client := ctx.Redis()
+		if client == nil {
+			ctx.Log.Error("idempotency store fail", zap.String("error", "redis is not enabled"))
+			ctx.Fail(http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		fingerprint, err := requestFingerprint(ctx)
+		if err != nil {
+			ctx.Fail(http.StatusBadRequest, "read body fail")
+			return
+		}
+
+		// 幂等键按注册的路由及用户隔离，配置中的路由可能以*匹配多个路由
+		pattern := ctx.Pattern
+		if pattern == "" {
+			pattern = ctx.Path
+		}
+		key := conf.Prefix + ":" + ctx.Method + ":" + pattern + ":" + ctx.GetString(UserIDKey) + ":" + idempotencyKey
+		lockKey := key + ":lock"
+		token := randomToken(16)
+		deadline := time.Now().Add(route.Wait)
+		for {
+			record, err := loadIdempotencyRecord(ctx, client, key)
+			if err != nil {
+				ctx.Log.Error("idempotency load fail", zap.Error(err))
+				ctx.Fail(http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			if record != nil {
+				if record.Fingerprint != fingerprint {
+					ctx.Fail(http.StatusUnprocessableEntity, conf.Header+" is reused with different request")
+					return
+				}
+				record.replay(ctx)
+				return
+			}
+
+			locked, err := client.SetNX(ctx, lockKey, token, route.LockTTL).Result()
+			if err != nil {
+				ctx.Log.Error("idempotency lock fail", zap.Error(err))
+				ctx.Fail(http.StatusInternalServerError, "Internal Server Error")
+				return
+			}
+			if locked {
+				break
+			}
+			// 首个请求仍在处理中，等待其完成后重放结果
+			if time.Now().Add(50 * time.Millisecond).After(deadline) {
+				ctx.Fail(http.StatusConflict, "request is processing")
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(50 * time.Millisecond):
+			}
+		}
+
+		// 请求结束后客户端可能已经断开，保存结果及释放锁不使用请求的上下文
+		w := ctx.Writer
+		iw := &idempotencyWriter{ResponseWriter: w}
+		ctx.Writer = iw
+		defer func() {
+			ctx.Writer = w
+			unlockScript.Run(context.Background(), globalRedisConnect, []string{lockKey}, token)
+		}()
+		ctx.Next()
+
+		// 服务端错误允许客户端重试
+		status := w.Status()
+		if status >= http.StatusInternalServerError {
+			return
+		}
+		// cookie属于首个请求的客户端，不能重放给其他请求
+		header := w.Header().Clone()
+		header.Del("Set-Cookie")
+		record, _ := json.Marshal(idempotencyRecord{
+			Fingerprint: fingerprint,
+			Status:      status,
+			Header:      header,
+			Body:        iw.body,
+		})
+		if err := globalRedisConnect.Set(context.Background(), key, record, route.TTL).Err(); err != nil {
+			ctx.Log.Error("idempotency store fail", zap.Error(err))
+		}
+	}
+}
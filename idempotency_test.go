@@ -0,0 +1,87 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdempotency(t *testing.T) {
+	s := useTestRedis(t)
+	idempotencyConf.Store(idempotencyConfig{Enable: true, Header: "Idempotency-Key", Prefix: "idempotency", Routes: []idempotencyRoute{
+		{routeRule: routeRule{Method: "POST", Path: "/order/*"}, TTL: time.Hour, LockTTL: time.Minute, Required: true},
+	}})
+	defer idempotencyConf.Store(idempotencyConfig{})
+
+	calls := 0
+	serve := func(pattern, key, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/order/1", strings.NewReader(body))
+		if key != "" {
+			r.Header.Set("Idempotency-Key", key)
+		}
+		ctx := newContext(w, r)
+		ctx.Pattern = pattern
+		ctx.handlers = []HandlerFunc{Idempotency(), func(ctx *Context) {
+			calls++
+			if strings.Contains(body, "fail") {
+				ctx.Fail(http.StatusInternalServerError, "fail")
+				return
+			}
+			http.SetCookie(ctx.Writer, &http.Cookie{Name: "session", Value: "secret"})
+			ctx.String(http.StatusCreated, "created "+pattern)
+		}}
+		ctx.Next()
+		return w
+	}
+
+	tests := []struct {
+		name     string
+		pattern  string
+		key      string
+		body     string
+		status   int
+		replayed bool
+		calls    int
+	}{
+		{"first request", "/order/:id", "k1", `{"a":1}`, http.StatusCreated, false, 1},
+		{"replay", "/order/:id", "k1", `{"a":1}`, http.StatusCreated, true, 1},
+		{"body mismatch", "/order/:id", "k1", `{"a":2}`, http.StatusUnprocessableEntity, false, 1},
+		{"other route same key", "/order/:id/pay", "k1", `{"a":1}`, http.StatusCreated, false, 2},
+		{"missing key", "/order/:id", "", `{"a":1}`, http.StatusBadRequest, false, 2},
+		{"server error", "/order/:id", "k2", `fail`, http.StatusInternalServerError, false, 3},
+		{"server error retry", "/order/:id", "k2", `fail`, http.StatusInternalServerError, false, 4},
+	}
+	for _, tt := range tests {
+		w := serve(tt.pattern, tt.key, tt.body)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != tt.replayed {
+			t.Errorf("%s: replayed = %v, want %v", tt.name, replayed, tt.replayed)
+		}
+		if tt.replayed {
+			if w.Header().Get("Set-Cookie") != "" {
+				t.Errorf("%s: replayed response should not contain Set-Cookie", tt.name)
+			}
+			if w.Body.String() != "created "+tt.pattern {
+				t.Errorf("%s: replayed body = %q", tt.name, w.Body.String())
+			}
+		}
+		if calls != tt.calls {
+			t.Errorf("%s: handler called %d times, want %d", tt.name, calls, tt.calls)
+		}
+	}
+
+	// 首个请求处理中时直接返回409
+	s.Set("idempotency:POST:/order/:id::k3:lock", "other")
+	if w := serve("/order/:id", "k3", `{"a":3}`); w.Code != http.StatusConflict {
+		t.Errorf("in flight: status = %d, want %d", w.Code, http.StatusConflict)
+	}
+	s.Del("idempotency:POST:/order/:id::k3:lock")
+	if w := serve("/order/:id", "k3", `{"a":3}`); w.Code != http.StatusCreated {
+		t.Errorf("after lock released: status = %d, want %d", w.Code, http.StatusCreated)
+	}
+}
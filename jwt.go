@@ -0,0 +1,592 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrTokenMissing = errors.New("token is missing")
+	ErrTokenInvalid = errors.New("token is invalid")
+	ErrTokenExpired = errors.New("token is expired")
+)
+
+// JwtClaimsKey jwt声明在上下文中的key
+var JwtClaimsKey = "jwt_claims"
+
+// JwtClaims jwt声明
+type JwtClaims map[string]interface{}
+
+// GetString 获取字符串类型的声明，数字类型会转换为字符串
+func (c JwtClaims) GetString(key string) string {
+	switch val := c[key].(type) {
+	case string:
+		return val
+	case float64:
+		return fmt.Sprintf("%.0f", val)
+	case json.Number:
+		return val.String()
+	}
+	return ""
+}
+
+// GetStringSlice 获取字符串数组类型的声明
+func (c JwtClaims) GetStringSlice(key string) []string {
+	switch val := c[key].(type) {
+	case []string:
+		return val
+	case string:
+		return []string{val}
+	case []interface{}:
+		list := make([]string, 0, len(val))
+		for _, item := range val {
+			if str, ok := item.(string); ok {
+				list = append(list, str)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// getTime 获取时间类型的声明
+func (c JwtClaims) getTime(key string) (time.Time, bool) {
+	switch val := c[key].(type) {
+	case float64:
+		return time.Unix(int64(val), 0), true
+	case int64:
+		return time.Unix(val, 0), true
+	case int:
+		return time.Unix(int64(val), 0), true
+	}
+	return time.Time{}, false
+}
+
+type jwtKeyConfig struct {
+	Kid        string `json:"kid" mapstructure:"kid"`
+	Algorithm  string `json:"algorithm" mapstructure:"algorithm"`     //HS256/RS256/ES256
+	Secret     string `json:"secret" mapstructure:"secret"`           //HS256密钥
+	PublicKey  string `json:"public_key" mapstructure:"public_key"`   //RS256/ES256公钥，PEM格式
+	PrivateKey string `json:"private_key" mapstructure:"private_key"` //RS256/ES256私钥，PEM格式，仅签发时需要
+}
+
+type jwtConfig struct {
+	jwtKeyConfig `mapstructure:",squash"` //单密钥的简写配置
+
+	Enable        bool           `json:"enable" mapstructure:"enable"`
+	Keys          []jwtKeyConfig `json:"keys" mapstructure:"keys"`                     //多密钥配置，用于密钥轮换
+	SignKid       string         `json:"sign_kid" mapstructure:"sign_kid"`             //签发使用的密钥
+	JwksFile      string         `json:"jwks_file" mapstructure:"jwks_file"`           //本地jwks文件，仅用于验证
+	Lookup        []string       `json:"lookup" mapstructure:"lookup"`                 //token获取位置 header:xxx/query:xxx/cookie:xxx
+	Scheme        string         `json:"scheme" mapstructure:"scheme"`                 //header中token的前缀，与token之间以空格分隔
+	Issuer        string         `json:"issuer" mapstructure:"issuer"`                 //签发者，不为空时进行校验
+	Expire        time.Duration  `json:"expire" mapstructure:"expire"`                 //token有效期
+	RefreshExpire time.Duration  `json:"refresh_expire" mapstructure:"refresh_expire"` //首次签发后允许刷新的时长
+	Leeway        time.Duration  `json:"leeway" mapstructure:"leeway"`                 //时间校验的容差
+	UserClaim     string         `json:"user_claim" mapstructure:"user_claim"`         //用户ID所在的声明
+
+	keys    map[string]*jwtKey
+	signKey *jwtKey
+}
+
+type jwtKey struct {
+	kid     string
+	alg     string
+	secret  []byte
+	public  crypto.PublicKey
+	private crypto.PrivateKey
+}
+
+var jwtConf atomic.Value
+
+func loadJwtConfig() *jwtConfig {
+	conf, _ := jwtConf.Load().(*jwtConfig)
+	return conf
+}
+
+// initJwtConfig 加载jwt配置及密钥，配置变更时重新加载实现密钥轮换
+// 热更新时密钥解析失败会继续使用原有的密钥
+func initJwtConfig(v *viper.Viper) {
+	conf, err := parseJwtConfig(v)
+	storeConfig("jwt", &jwtConf, conf, err)
+}
+
+func parseJwtConfig(v *viper.Viper) (*jwtConfig, error) {
+	conf := &jwtConfig{}
+	if err := v.UnmarshalKey("jwt", conf); err != nil {
+		return nil, err
+	}
+	if len(conf.Lookup) == 0 {
+		conf.Lookup = []string{"header:Authorization"}
+	}
+	if conf.Scheme == "" {
+		conf.Scheme = "Bearer"
+	}
+	if conf.Expire == 0 {
+		conf.Expire = 2 * time.Hour
+	}
+	if conf.RefreshExpire == 0 {
+		conf.RefreshExpire = 7 * 24 * time.Hour
+	}
+	if conf.UserClaim == "" {
+		conf.UserClaim = "sub"
+	}
+
+	conf.keys = map[string]*jwtKey{}
+	keys := conf.Keys
+	if conf.Secret != "" || conf.PublicKey != "" || conf.PrivateKey != "" {
+		keys = append([]jwtKeyConfig{conf.jwtKeyConfig}, keys...)
+	}
+	for _, item := range keys {
+		key, err := parseJwtKey(item)
+		if err != nil {
+			return nil, err
+		}
+		// 多个密钥按kid区分，kid为空或重复时后面的密钥会覆盖前面的密钥
+		if len(keys) > 1 && key.kid == "" {
+			return nil, errors.New("jwt kid is required when multiple keys are configured")
+		}
+		if _, ok := conf.keys[key.kid]; ok {
+			return nil, errors.New("jwt kid is duplicated:" + key.kid)
+		}
+		conf.keys[key.kid] = key
+		if conf.signKey == nil && key.canSign() {
+			conf.signKey = key
+		}
+	}
+	if conf.SignKid != "" {
+		key, ok := conf.keys[conf.SignKid]
+		if !ok || !key.canSign() {
+			return nil, errors.New("jwt sign key not found:" + conf.SignKid)
+		}
+		conf.signKey = key
+	}
+
+	if conf.JwksFile != "" {
+		keys, err := parseJwksFile(conf.JwksFile)
+		if err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if _, ok := conf.keys[key.kid]; !ok {
+				conf.keys[key.kid] = key
+			}
+		}
+	}
+	return conf, nil
+}
+
+func parseJwtKey(conf jwtKeyConfig) (*jwtKey, error) {
+	key := &jwtKey{kid: conf.Kid, alg: strings.ToUpper(conf.Algorithm)}
+	if key.alg == "" {
+		key.alg = "HS256"
+	}
+	switch key.alg {
+	case "HS256":
+		if conf.Secret == "" {
+			return nil, errors.New("jwt secret is empty")
+		}
+		key.secret = []byte(conf.Secret)
+		return key, nil
+	case "RS256", "ES256":
+	default:
+		return nil, errors.New("jwt algorithm not support:" + conf.Algorithm)
+	}
+
+	if conf.PrivateKey != "" {
+		block, _ := pem.Decode([]byte(conf.PrivateKey))
+		if block == nil {
+			return nil, errors.New("jwt private key is invalid")
+		}
+		private, err := parsePrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.private = private
+		if signer, ok := private.(crypto.Signer); ok {
+			key.public = signer.Public()
+		}
+	}
+	if conf.PublicKey != "" {
+		block, _ := pem.Decode([]byte(conf.PublicKey))
+		if block == nil {
+			return nil, errors.New("jwt public key is invalid")
+		}
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		key.public = public
+	}
+	if key.public == nil {
+		return nil, errors.New("jwt public key is empty")
+	}
+	return key, nil
+}
+
+func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+	return x509.ParseECPrivateKey(der)
+}
+
+// parseJwksFile 解析本地的jwks文件
+func parseJwksFile(path string) ([]*jwtKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	jwks := struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Alg string `json:"alg"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}{}
+	if err = json.Unmarshal(data, &jwks); err != nil {
+		return nil, err
+	}
+
+	decode := base64.RawURLEncoding.DecodeString
+	var keys []*jwtKey
+	for _, item := range jwks.Keys {
+		key := &jwtKey{kid: item.Kid, alg: item.Alg}
+		switch item.Kty {
+		case "oct":
+			if key.secret, err = decode(item.K); err != nil {
+				return nil, err
+			}
+			key.alg = "HS256"
+		case "RSA":
+			n, err := decode(item.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := decode(item.E)
+			if err != nil {
+				return nil, err
+			}
+			key.public = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+			key.alg = "RS256"
+		case "EC":
+			if item.Crv != "P-256" {
+				continue
+			}
+			x, err := decode(item.X)
+			if err != nil {
+				return nil, err
+			}
+			y, err := decode(item.Y)
+			if err != nil {
+				return nil, err
+			}
+			key.public = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+			key.alg = "ES256"
+		default:
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (k *jwtKey) canSign() bool {
+	return k.secret != nil || k.private != nil
+}
+
+func (k *jwtKey) sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+	switch k.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	case "RS256":
+		private, ok := k.private.(*rsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("jwt rsa private key is invalid")
+		}
+		return rsa.SignPKCS1v15(rand.Reader, private, crypto.SHA256, digest[:])
+	case "ES256":
+		private, ok := k.private.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("jwt ecdsa private key is invalid")
+		}
+		r, s, err := ecdsa.Sign(rand.Reader, private, digest[:])
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	}
+	return nil, errors.New("jwt algorithm not support:" + k.alg)
+}
+
+func (k *jwtKey) verify(data, sig []byte) bool {
+	digest := sha256.Sum256(data)
+	switch k.alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(data)
+		return hmac.Equal(mac.Sum(nil), sig)
+	case "RS256":
+		public, ok := k.public.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(public, crypto.SHA256, digest[:], sig) == nil
+	case "ES256":
+		public, ok := k.public.(*ecdsa.PublicKey)
+		if !ok || len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(public, digest[:], r, s)
+	}
+	return false
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// signJwt 使用签发密钥生成token
+func signJwt(conf *jwtConfig, claims JwtClaims) (string, error) {
+	if conf.signKey == nil {
+		return "", errors.New("jwt sign key not found")
+	}
+	header, err := json.Marshal(jwtHeader{Alg: conf.signKey.alg, Typ: "JWT", Kid: conf.signKey.kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encode := base64.RawURLEncoding.EncodeToString
+	data := encode(header) + "." + encode(payload)
+	sig, err := conf.signKey.sign([]byte(data))
+	if err != nil {
+		return "", err
+	}
+	return data + "." + encode(sig), nil
+}
+
+// parseJwt 校验签名并解析声明，token必须包含过期时间，checkExpire为false时不校验是否过期
+func parseJwt(conf *jwtConfig, token string, checkExpire bool) (JwtClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrTokenInvalid
+	}
+	decode := base64.RawURLEncoding.DecodeString
+	headerData, err := decode(parts[0])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	header := jwtHeader{}
+	if err = json.Unmarshal(headerData, &header); err != nil {
+		return nil, ErrTokenInvalid
+	}
+	sig, err := decode(parts[2])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	// 指定了kid时只使用对应的密钥，否则尝试所有同算法的密钥；算法必须与密钥一致
+	var keys []*jwtKey
+	if key, ok := conf.keys[header.Kid]; ok {
+		keys = append(keys, key)
+	} else if header.Kid == "" {
+		for _, key := range conf.keys {
+			keys = append(keys, key)
+		}
+	}
+	verified := false
+	for _, key := range keys {
+		if key.alg == header.Alg && key.verify([]byte(parts[0]+"."+parts[1]), sig) {
+			verified = true
+			break
+		}
+	}
+	if !verified {
+		return nil, ErrTokenInvalid
+	}
+
+	payload, err := decode(parts[1])
+	if err != nil {
+		return nil, ErrTokenInvalid
+	}
+	claims := JwtClaims{}
+	if err = json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrTokenInvalid
+	}
+
+	// 没有过期时间的token永久有效，不予接受
+	now := time.Now()
+	exp, ok := claims.getTime("exp")
+	if !ok {
+		return nil, ErrTokenInvalid
+	}
+	if checkExpire && now.After(exp.Add(conf.Leeway)) {
+		return nil, ErrTokenExpired
+	}
+	if nbf, ok := claims.getTime("nbf"); ok && now.Add(conf.Leeway).Before(nbf) {
+		return nil, ErrTokenInvalid
+	}
+	if conf.Issuer != "" && claims.GetString("iss") != conf.Issuer {
+		return nil, ErrTokenInvalid
+	}
+	return claims, nil
+}
+
+// IssueToken 签发token，未设置过期时间时使用配置的有效期
+func IssueToken(claims JwtClaims) (string, error) {
+	conf := loadJwtConfig()
+	if conf == nil {
+		return "", errors.New("jwt is not configured")
+	}
+	now := time.Now()
+	data := JwtClaims{}
+	for key, val := range claims {
+		data[key] = val
+	}
+	data["iat"] = now.Unix()
+	if _, ok := data["exp"]; !ok {
+		data["exp"] = now.Add(conf.Expire).Unix()
+	}
+	if _, ok := data["orig_iat"]; !ok {
+		data["orig_iat"] = now.Unix()
+	}
+	if _, ok := data["iss"]; !ok && conf.Issuer != "" {
+		data["iss"] = conf.Issuer
+	}
+	return signJwt(conf, data)
+}
+
+// RefreshToken 刷新token，已过期的token在首次签发后的 refresh_expire 内仍可刷新
+func RefreshToken(token string) (string, error) {
+	conf := loadJwtConfig()
+	if conf == nil {
+		return "", errors.New("jwt is not configured")
+	}
+	claims, err := parseJwt(conf, token, false)
+	if err != nil {
+		return "", err
+	}
+	orig, ok := claims.getTime("orig_iat")
+	if !ok {
+		orig, _ = claims.getTime("iat")
+	}
+	if time.Since(orig) > conf.RefreshExpire {
+		return "", ErrTokenExpired
+	}
+	delete(claims, "exp")
+	claims["orig_iat"] = orig.Unix()
+	return IssueToken(claims)
+}
+
+// ParseToken 校验并解析token
+func ParseToken(token string) (JwtClaims, error) {
+	conf := loadJwtConfig()
+	if conf == nil {
+		return nil, errors.New("jwt is not configured")
+	}
+	return parseJwt(conf, token, true)
+}
+
+// lookupToken 按配置的顺序获取token
+func lookupToken(ctx *Context, conf *jwtConfig) string {
+	for _, item := range conf.Lookup {
+		parts := strings.SplitN(item, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		var token string
+		switch parts[0] {
+		case "header":
+			token = ctx.Request.Header.Get(parts[1])
+			// 配置了前缀时必须以 前缀+空格 开头，例如 Bearer xxx
+			if conf.Scheme != "" {
+				prefix := conf.Scheme + " "
+				if len(token) > len(prefix) && strings.EqualFold(token[:len(prefix)], prefix) {
+					token = strings.TrimSpace(token[len(prefix):])
+				} else {
+					token = ""
+				}
+			}
+		case "query":
+			token = ctx.Request.URL.Query().Get(parts[1])
+		case "cookie":
+			if cookie, err := ctx.Request.Cookie(parts[1]); err == nil {
+				token = cookie.Value
+			}
+		}
+		if token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+// JWT jwt认证，认证通过后声明及用户ID写入上下文，链路日志携带用户ID
+func JWT() HandlerFunc {
+	return func(ctx *Context) {
+		conf := loadJwtConfig()
+		if conf == nil || !conf.Enable {
+			return
+		}
+		token := lookupToken(ctx, conf)
+		if token == "" {
+			ctx.Fail(http.StatusUnauthorized, ErrTokenMissing.Error())
+			return
+		}
+		claims, err := parseJwt(conf, token, true)
+		if err != nil {
+			ctx.Fail(http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		ctx.SetValue(JwtClaimsKey, claims)
+		if id := claims.GetString(conf.UserClaim); id != "" {
+			ctx.SetValue(UserIDKey, id)
+			ctx.Log = ctx.Log.With(zap.String(UserIDKey, id))
+		}
+	}
+}
+
+// Claims 获取jwt认证后的声明
+func (c *Context) Claims() JwtClaims {
+	claims, _ := c.Context.Value(JwtClaimsKey).(JwtClaims)
+	return claims
+}
@@ -0,0 +1,189 @@
+package core
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestJwt(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	rsaDer, _ := x509.MarshalPKCS8PrivateKey(rsaKey)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ecDer, _ := x509.MarshalPKCS8PrivateKey(ecKey)
+
+	keys := []H{
+		{"kid": "hs", "algorithm": "HS256", "secret": "secret"},
+		{"kid": "rs", "algorithm": "RS256", "private_key": string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rsaDer}))},
+		{"kid": "es", "algorithm": "ES256", "private_key": string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: ecDer}))},
+	}
+	for _, key := range keys {
+		v := viper.New()
+		v.Set("jwt", H{"enable": true, "keys": keys, "sign_kid": key["kid"], "issuer": "core"})
+		conf, err := parseJwtConfig(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwtConf.Store(conf)
+
+		token, err := IssueToken(JwtClaims{"sub": "1001"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		claims, err := ParseToken(token)
+		if err != nil || claims.GetString("sub") != "1001" {
+			t.Fatalf("%s parse token fail: %v", key["kid"], err)
+		}
+		parts := strings.Split(token, ".")
+		parts[1] = base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"1002"}`))
+		if _, err = ParseToken(strings.Join(parts, ".")); err != ErrTokenInvalid {
+			t.Fatalf("%s tampered token should be invalid: %v", key["kid"], err)
+		}
+
+		expired, _ := IssueToken(JwtClaims{"sub": "1001", "exp": time.Now().Add(-time.Minute).Unix()})
+		if _, err = ParseToken(expired); err != ErrTokenExpired {
+			t.Fatalf("%s token should be expired: %v", key["kid"], err)
+		}
+		refreshed, err := RefreshToken(expired)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = ParseToken(refreshed); err != nil {
+			t.Fatalf("%s refreshed token is invalid: %v", key["kid"], err)
+		}
+	}
+}
+
+func TestJwtKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		jwt  H
+		ok   bool
+	}{
+		{"single key without kid", H{"secret": "a"}, true},
+		{"multiple keys", H{"keys": []H{{"kid": "1", "secret": "a"}, {"kid": "2", "secret": "b"}}}, true},
+		{"empty kid", H{"keys": []H{{"secret": "a"}, {"kid": "2", "secret": "b"}}}, false},
+		{"shorthand without kid", H{"secret": "a", "keys": []H{{"kid": "2", "secret": "b"}}}, false},
+		{"duplicated kid", H{"keys": []H{{"kid": "1", "secret": "a"}, {"kid": "1", "secret": "b"}}}, false},
+	}
+	for _, tt := range tests {
+		v := viper.New()
+		v.Set("jwt", tt.jwt)
+		if _, err := parseJwtConfig(v); (err == nil) != tt.ok {
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		}
+	}
+}
+
+func TestJwtRotation(t *testing.T) {
+	defer jwtConf.Store(&jwtConfig{})
+	store := func(jwt H) {
+		v := viper.New()
+		v.Set("jwt", jwt)
+		conf, err := parseJwtConfig(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		jwtConf.Store(conf)
+	}
+
+	store(H{"keys": []H{{"kid": "old", "secret": "a"}}})
+	old, _ := IssueToken(JwtClaims{"sub": "1"})
+	// 新密钥用于签发，旧密钥保留用于验证已签发的token
+	store(H{"keys": []H{{"kid": "old", "secret": "a"}, {"kid": "new", "secret": "b"}}, "sign_kid": "new"})
+	token, _ := IssueToken(JwtClaims{"sub": "1"})
+	if _, err := ParseToken(old); err != nil {
+		t.Fatalf("token signed by old key should be valid: %v", err)
+	}
+	if _, err := ParseToken(token); err != nil {
+		t.Fatalf("token signed by new key should be valid: %v", err)
+	}
+	store(H{"keys": []H{{"kid": "new", "secret": "b"}}})
+	if _, err := ParseToken(old); err != ErrTokenInvalid {
+		t.Fatalf("token signed by removed key should be invalid: %v", err)
+	}
+	if _, err := ParseToken(token); err != nil {
+		t.Fatalf("token signed by new key should be valid: %v", err)
+	}
+
+	noExp, _ := signJwt(loadJwtConfig(), JwtClaims{"sub": "1"})
+	if _, err := ParseToken(noExp); err != ErrTokenInvalid {
+		t.Fatalf("token without exp should be invalid: %v", err)
+	}
+}
+
+func TestJwtMiddleware(t *testing.T) {
+	v := viper.New()
+	v.Set("jwt", H{"enable": true, "secret": "secret", "lookup": []string{"header:Authorization", "query:token", "cookie:token"}})
+	conf, err := parseJwtConfig(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwtConf.Store(conf)
+	defer jwtConf.Store(&jwtConfig{})
+	token, _ := IssueToken(JwtClaims{"sub": "1001"})
+
+	tests := []struct {
+		name   string
+		header string
+		query  string
+		cookie string
+		status int
+	}{
+		{"header", "Bearer " + token, "", "", http.StatusOK},
+		{"header case insensitive", "bearer " + token, "", "", http.StatusOK},
+		{"header without separator", "Bearer" + token, "", "", http.StatusUnauthorized},
+		{"header without scheme", token, "", "", http.StatusUnauthorized},
+		{"query", "", token, "", http.StatusOK},
+		{"cookie", "", "", token, http.StatusOK},
+		{"missing", "", "", "", http.StatusUnauthorized},
+		{"invalid", "Bearer " + token + "x", "", "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/?token="+tt.query, nil)
+		if tt.header != "" {
+			r.Header.Set("Authorization", tt.header)
+		}
+		if tt.cookie != "" {
+			r.AddCookie(&http.Cookie{Name: "token", Value: tt.cookie})
+		}
+		core, logs := observer.New(zap.InfoLevel)
+		w := httptest.NewRecorder()
+		ctx := newContext(w, r)
+		ctx.Log = zap.New(core)
+		var userID string
+		ctx.handlers = []HandlerFunc{JWT(), func(ctx *Context) {
+			userID = ctx.GetString(UserIDKey)
+			ctx.Log.Info("handled")
+			ctx.String(http.StatusOK, ctx.Claims().GetString("sub"))
+		}}
+		ctx.Next()
+		if w.Code != tt.status {
+			t.Errorf("%s: got status %d", tt.name, w.Code)
+			continue
+		}
+		if tt.status != http.StatusOK {
+			continue
+		}
+		if userID != "1001" || w.Body.String() != "1001" {
+			t.Errorf("%s: user id %q claims %q", tt.name, userID, w.Body.String())
+		}
+		entries := logs.FilterMessage("handled").All()
+		if len(entries) != 1 || entries[0].ContextMap()[UserIDKey] != "1001" {
+			t.Errorf("%s: log should carry user id", tt.name)
+		}
+	}
+}
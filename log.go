@@ -63,15 +63,32 @@ func initLogKey(v *viper.Viper) {
 
 func initLog(v *viper.Viper, srvName string) *zap.Logger {
 	conf := parseLogConf(v)
-	hook := lumberjack.Logger{
-		Filename:   conf.Filename,   // 日志文件路径
-		MaxSize:    conf.MaxSize,    // 每个日志文件保存的最大尺寸 单位：M
-		MaxBackups: conf.MaxBackups, // 日志文件最多保存多少个备份
-		MaxAge:     conf.MaxAge,     // 文件最多保存多少天
-		Compress:   conf.Compress,   // 是否压缩
+
+	// 设置日志级别
+	atomicLevel := zap.NewAtomicLevel()
+	atomicLevel.SetLevel(zapcore.Level(conf.Level))
+
+	core := zapcore.NewCore(
+		zapcore.NewJSONEncoder(newEncoderConfig()), // 编码器配置
+		newLogWriter(conf),                         // 输出方式
+		atomicLevel,                                // 日志级别
+	)
+	// error及以上级别的日志同时发送告警
+	core = zapcore.NewTee(core, newAlertCore())
+
+	var ops []zap.Option
+	// 开启开发模式，堆栈跟踪
+	if conf.Debug {
+		ops = append(ops, zap.AddCaller())
 	}
+	// 开启文件及行号,设置初始化字段
+	ops = append(ops, zap.Development(), zap.Fields(zap.String("service", srvName)))
+	// 构造日志
+	return zap.New(core, ops...)
+}
 
-	encoderConfig := zapcore.EncoderConfig{
+func newEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
 		TimeKey:        "time",
 		LevelKey:       "level",
 		NameKey:        "logger",
@@ -84,32 +101,22 @@ func initLog(v *viper.Viper, srvName string) *zap.Logger {
 		EncodeDuration: zapcore.SecondsDurationEncoder,
 		EncodeCaller:   zapcore.FullCallerEncoder, // 全路径编码器
 	}
+}
 
-	// 设置日志级别
-	atomicLevel := zap.NewAtomicLevel()
-	atomicLevel.SetLevel(zapcore.Level(conf.Level))
-
-	// 设置输出方式
+// newLogWriter 设置输出方式
+func newLogWriter(conf LogConfig) zapcore.WriteSyncer {
 	var syncOps []zapcore.WriteSyncer
 	if conf.OutputFile {
-		syncOps = append(syncOps, zapcore.AddSync(&hook))
+		syncOps = append(syncOps, zapcore.AddSync(&lumberjack.Logger{
+			Filename:   conf.Filename,   // 日志文件路径
+			MaxSize:    conf.MaxSize,    // 每个日志文件保存的最大尺寸 单位：M
+			MaxBackups: conf.MaxBackups, // 日志文件最多保存多少个备份
+			MaxAge:     conf.MaxAge,     // 文件最多保存多少天
+			Compress:   conf.Compress,   // 是否压缩
+		}))
 	}
 	if conf.OutputConsole {
 		syncOps = append(syncOps, zapcore.AddSync(os.Stdout))
 	}
-	core := zapcore.NewCore(
-		zapcore.NewJSONEncoder(encoderConfig),   // 编码器配置
-		zapcore.NewMultiWriteSyncer(syncOps...), // 输出方式
-		atomicLevel,                             // 日志级别
-	)
-
-	var ops []zap.Option
-	// 开启开发模式，堆栈跟踪
-	if conf.Debug {
-		ops = append(ops, zap.AddCaller())
-	}
-	// 开启文件及行号,设置初始化字段
-	ops = append(ops, zap.Development(), zap.Fields(zap.String("service", srvName)))
-	// 构造日志
-	return zap.New(core, ops...)
+	return zapcore.NewMultiWriteSyncer(syncOps...)
 }
@@ -0,0 +1,28 @@
+package core
+
+import (
+	"os"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+func TestMain(m *testing.M) {
+	// 测试中不初始化服务，使用空日志
+	globalLog = zap.NewNop()
+	os.Exit(m.Run())
+}
+
+// useTestRedis 使用内存redis替换全局连接，测试结束后恢复
+func useTestRedis(t *testing.T) *miniredis.Miniredis {
+	s := miniredis.RunT(t)
+	client := globalRedisConnect
+	globalRedisConnect = redis.NewClient(&redis.Options{Addr: s.Addr()})
+	t.Cleanup(func() {
+		globalRedisConnect.Close()
+		globalRedisConnect = client
+	})
+	return s
+}
@@ -0,0 +1,128 @@
+package core
+
+import (
+	"crypto/subtle"
+	"github.com/spf13/viper"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type killSwitch struct {
+	routeRule `mapstructure:",squash"`
+	Message   string `json:"message" mapstructure:"message"` //为空时使用 maintenance.message
+}
+
+type maintenanceConfig struct {
+	Enable        bool              `json:"enable" mapstructure:"enable"`                 //开启维护模式
+	Message       string            `json:"message" mapstructure:"message"`               //返回的提示信息
+	RetryAfter    time.Duration     `json:"retry_after" mapstructure:"retry_after"`       //建议客户端的重试时间，为0时不返回 Retry-After
+	Routes        []routeRule       `json:"routes" mapstructure:"routes"`                 //维护的路由，以*结尾匹配整个分组，为空时维护所有路由
+	ExcludeRoutes []routeRule       `json:"exclude_routes" mapstructure:"exclude_routes"` //维护期间仍然可用的路由，例如健康检查
+	KillSwitches  []killSwitch      `json:"kill_switches" mapstructure:"kill_switches"`   //单独关闭的路由，不受 enable 影响
+	AllowIPs      []string          `json:"allow_ips" mapstructure:"allow_ips"`           //放行的ip或网段
+	AllowHeaders  map[string]string `json:"allow_headers" mapstructure:"allow_headers"`   //放行的请求头及其值
+
+	allowNets []*net.IPNet
+}
+
+var maintenanceConf atomic.Value
+
+func initMaintenanceConfig(v *viper.Viper) {
+	conf, err := parseMaintenanceConfig(v)
+	storeConfig("maintenance", &maintenanceConf, conf, err)
+}
+
+func parseMaintenanceConfig(v *viper.Viper) (*maintenanceConfig, error) {
+	conf := maintenanceConfig{}
+	if err := v.UnmarshalKey("maintenance", &conf); err != nil {
+		return nil, err
+	}
+	if conf.Message == "" {
+		conf.Message = "系统维护中，请稍后再试"
+	}
+	for _, item := range conf.AllowIPs {
+		ipNet, err := parseIPNet(item)
+		if err != nil {
+			return nil, err
+		}
+		conf.allowNets = append(conf.allowNets, ipNet)
+	}
+	return &conf, nil
+}
+
+func loadMaintenanceConfig() *maintenanceConfig {
+	conf, _ := maintenanceConf.Load().(*maintenanceConfig)
+	if conf == nil {
+		return &maintenanceConfig{}
+	}
+	return conf
+}
+
+// allowed 判断请求是否在白名单中，ip只在来自 system.trusted_proxies 时才从代理头中获取，伪造的代理头无法绕过维护
+func (c *maintenanceConfig) allowed(ctx *Context) bool {
+	if len(c.allowNets) != 0 {
+		if ip := net.ParseIP(ctx.ClientIP()); ip != nil {
+			for _, item := range c.allowNets {
+				if item.Contains(ip) {
+					return true
+				}
+			}
+		}
+	}
+	for name, val := range c.AllowHeaders {
+		if got := ctx.Request.Header.Get(name); got != "" && subtle.ConstantTimeCompare([]byte(got), []byte(val)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// blocked 判断路由是否处于维护中，返回提示信息
+func (c *maintenanceConfig) blocked(ctx *Context) (string, bool) {
+	for _, item := range c.KillSwitches {
+		if item.match(ctx) {
+			if item.Message != "" {
+				return item.Message, true
+			}
+			return c.Message, true
+		}
+	}
+	if !c.Enable {
+		return "", false
+	}
+	for _, item := range c.ExcludeRoutes {
+		if item.match(ctx) {
+			return "", false
+		}
+	}
+	if len(c.Routes) == 0 {
+		return c.Message, true
+	}
+	for _, item := range c.Routes {
+		if item.match(ctx) {
+			return c.Message, true
+		}
+	}
+	return "", false
+}
+
+// Maintenance 维护模式及路由开关，配置中心修改后立即生效，白名单中的ip及请求头仍然可以访问
+func Maintenance() HandlerFunc {
+	return func(ctx *Context) {
+		conf := loadMaintenanceConfig()
+		if !conf.Enable && len(conf.KillSwitches) == 0 {
+			return
+		}
+		message, blocked := conf.blocked(ctx)
+		if !blocked || conf.allowed(ctx) {
+			return
+		}
+		if conf.RetryAfter > 0 {
+			ctx.SetHeader("Retry-After", strconv.FormatInt(ceilSeconds(conf.RetryAfter), 10))
+		}
+		ctx.Fail(http.StatusServiceUnavailable, message)
+	}
+}
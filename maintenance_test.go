@@ -0,0 +1,89 @@
+package core
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestMaintenance(t *testing.T) {
+	system := globalSystemConfig
+	proxy, _ := parseIPNet("10.0.0.1")
+	globalSystemConfig.trustedNets = []*net.IPNet{proxy}
+	defer func() { globalSystemConfig = system }()
+
+	v := viper.New()
+	v.Set("maintenance", map[string]interface{}{
+		"enable":         true,
+		"retry_after":    "90s",
+		"routes":         []map[string]string{{"path": "/order/*"}},
+		"exclude_routes": []map[string]string{{"method": "GET", "path": "/order/health"}},
+		"kill_switches":  []map[string]string{{"method": "POST", "path": "/pay", "message": "支付暂停"}},
+		"allow_ips":      []string{"192.168.1.0/24"},
+		"allow_headers":  map[string]string{"X-Maintenance-Token": "token"},
+	})
+	initMaintenanceConfig(v)
+	defer maintenanceConf.Store(&maintenanceConfig{})
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		remote  string
+		header  map[string]string
+		status  int
+		message string
+	}{
+		{"maintained route", "GET", "/order/1", "1.1.1.1:80", nil, http.StatusServiceUnavailable, "系统维护中，请稍后再试"},
+		{"other route", "GET", "/user/1", "1.1.1.1:80", nil, http.StatusOK, ""},
+		{"excluded route", "GET", "/order/health", "1.1.1.1:80", nil, http.StatusOK, ""},
+		{"kill switch", "POST", "/pay", "1.1.1.1:80", nil, http.StatusServiceUnavailable, "支付暂停"},
+		{"kill switch other method", "GET", "/pay", "1.1.1.1:80", nil, http.StatusOK, ""},
+		{"allowed ip", "GET", "/order/1", "192.168.1.5:80", nil, http.StatusOK, ""},
+		{"allowed ip via trusted proxy", "GET", "/order/1", "10.0.0.1:80", map[string]string{"X-Forwarded-For": "192.168.1.5"}, http.StatusOK, ""},
+		{"spoofed forwarded ip", "GET", "/order/1", "1.1.1.1:80", map[string]string{"X-Forwarded-For": "192.168.1.5"}, http.StatusServiceUnavailable, "系统维护中，请稍后再试"},
+		{"allowed header", "GET", "/order/1", "1.1.1.1:80", map[string]string{"X-Maintenance-Token": "token"}, http.StatusOK, ""},
+		{"wrong header", "GET", "/order/1", "1.1.1.1:80", map[string]string{"X-Maintenance-Token": "other"}, http.StatusServiceUnavailable, "系统维护中，请稍后再试"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		r.RemoteAddr = tt.remote
+		for name, val := range tt.header {
+			r.Header.Set(name, val)
+		}
+		ctx := newContext(w, r)
+		ctx.handlers = []HandlerFunc{Maintenance(), func(ctx *Context) { ctx.String(http.StatusOK, "ok") }}
+		ctx.Next()
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+			continue
+		}
+		if tt.status == http.StatusServiceUnavailable {
+			if w.Header().Get("Retry-After") != "90" {
+				t.Errorf("%s: Retry-After = %q", tt.name, w.Header().Get("Retry-After"))
+			}
+			if !strings.Contains(w.Body.String(), tt.message) {
+				t.Errorf("%s: body = %s, want message %s", tt.name, w.Body.String(), tt.message)
+			}
+		}
+	}
+
+	// 关闭维护模式后路由开关仍然生效
+	conf := *loadMaintenanceConfig()
+	conf.Enable, conf.RetryAfter = false, 0
+	maintenanceConf.Store(&conf)
+	for path, status := range map[string]int{"/order/1": http.StatusOK, "/pay": http.StatusServiceUnavailable} {
+		w := httptest.NewRecorder()
+		ctx := newContext(w, httptest.NewRequest(http.MethodPost, path, nil))
+		ctx.handlers = []HandlerFunc{Maintenance(), func(ctx *Context) { ctx.String(http.StatusOK, "ok") }}
+		ctx.Next()
+		if w.Code != status || w.Header().Get("Retry-After") != "" {
+			t.Errorf("maintenance disabled %s: status = %d, Retry-After = %q", path, w.Code, w.Header().Get("Retry-After"))
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package core
+
+import (
+	"encoding/json"
+	"github.com/spf13/viper"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// 内置的脱敏规则
+var maskBuiltins = map[string]maskPattern{
+	"phone":     {Regexp: `\b(1[3-9]\d)\d{4}(\d{4})\b`, Replace: "$1****$2"},
+	"id_card":   {Regexp: `\b(\d{4})\d{10}(\d{3}[\dXx])\b`, Replace: "$1**********$2"},
+	"email":     {Regexp: `([A-Za-z0-9._%+-])[A-Za-z0-9._%+-]*(@[A-Za-z0-9.-]+\.[A-Za-z]{2,})`, Replace: "$1****$2"},
+	"bank_card": {Regexp: `\b(\d{4})\d{8,11}(\d{4})\b`, Replace: "$1********$2"},
+}
+
+type maskPattern struct {
+	Regexp  string `json:"regexp" mapstructure:"regexp"`
+	Replace string `json:"replace" mapstructure:"replace"`
+}
+
+type maskConfig struct {
+	Fields      []string      `json:"fields" mapstructure:"fields"`           //需要脱敏的json字段，不含.时匹配任意层级，含.时从根节点匹配，*匹配任意字段或数组元素
+	Headers     []string      `json:"headers" mapstructure:"headers"`         //需要脱敏的请求头
+	Builtins    []string      `json:"builtins" mapstructure:"builtins"`       //启用的内置规则 phone/id_card/email/bank_card
+	Patterns    []maskPattern `json:"patterns" mapstructure:"patterns"`       //自定义正则规则
+	Replacement string        `json:"replacement" mapstructure:"replacement"` //字段及请求头脱敏后的值
+
+	fields   map[string]bool
+	paths    [][]string
+	headers  map[string]bool
+	patterns []*maskRegexp
+	field    *regexp.Regexp // 无法解析为json时按字段名匹配
+}
+
+type maskRegexp struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+var maskConf atomic.Value
+
+func initMaskConfig(v *viper.Viper) {
+	conf, err := parseMaskConfig(v)
+	storeConfig("mask", &maskConf, conf, err)
+}
+
+func parseMaskConfig(v *viper.Viper) (*maskConfig, error) {
+	conf := maskConfig{}
+	if err := v.UnmarshalKey("mask", &conf); err != nil {
+		return nil, err
+	}
+	if conf.Replacement == "" {
+		conf.Replacement = "******"
+	}
+	conf.fields = map[string]bool{}
+	for _, item := range conf.Fields {
+		if strings.Contains(item, ".") {
+			conf.paths = append(conf.paths, strings.Split(item, "."))
+			continue
+		}
+		conf.fields[strings.ToLower(item)] = true
+	}
+	var names []string
+	for item := range conf.fields {
+		names = append(names, regexp.QuoteMeta(item))
+	}
+	for _, path := range conf.paths {
+		if name := path[len(path)-1]; name != "*" {
+			names = append(names, regexp.QuoteMeta(strings.ToLower(name)))
+		}
+	}
+	if len(names) != 0 {
+		field, err := regexp.Compile(`(?i)("(?:` + strings.Join(names, "|") + `)"\s*:\s*)("(?:[^"\\]|\\.)*"?|[^,}\]\s]+)`)
+		if err != nil {
+			return nil, err
+		}
+		conf.field = field
+	}
+	conf.headers = map[string]bool{}
+	for _, item := range conf.Headers {
+		conf.headers[http.CanonicalHeaderKey(item)] = true
+	}
+	var patterns []maskPattern
+	for _, name := range conf.Builtins {
+		if item, ok := maskBuiltins[name]; ok {
+			patterns = append(patterns, item)
+		}
+	}
+	for _, item := range append(patterns, conf.Patterns...) {
+		re, err := regexp.Compile(item.Regexp)
+		if err != nil {
+			return nil, err
+		}
+		conf.patterns = append(conf.patterns, &maskRegexp{re: re, replace: item.Replace})
+	}
+	return &conf, nil
+}
+
+func loadMaskConfig() *maskConfig {
+	conf, _ := maskConf.Load().(*maskConfig)
+	if conf == nil {
+		return &maskConfig{}
+	}
+	return conf
+}
+
+// MaskString 使用正则规则对文本脱敏
+func MaskString(data string) string {
+	return loadMaskConfig().maskString(data)
+}
+
+// MaskHeader 对请求头脱敏，返回脱敏后的副本
+func MaskHeader(header http.Header) http.Header {
+	return loadMaskConfig().maskHeader(header)
+}
+
+// MaskBody 对请求体或响应体脱敏，json数据先按字段脱敏再使用正则脱敏
+func MaskBody(data []byte) string {
+	return loadMaskConfig().maskBody(data)
+}
+
+func (c *maskConfig) maskString(data string) string {
+	for _, item := range c.patterns {
+		data = item.re.ReplaceAllString(data, item.replace)
+	}
+	return data
+}
+
+func (c *maskConfig) maskHeader(header http.Header) http.Header {
+	res := make(http.Header, len(header))
+	for key, val := range header {
+		if c.headers[http.CanonicalHeaderKey(key)] {
+			res[key] = []string{c.Replacement}
+			continue
+		}
+		res[key] = val
+	}
+	return res
+}
+
+func (c *maskConfig) maskBody(data []byte) string {
+	if c.field == nil {
+		return c.maskString(string(data))
+	}
+	var val interface{}
+	if err := json.Unmarshal(data, &val); err != nil {
+		// 截断或格式错误的数据按字段名匹配，路径规则退化为匹配最后一级字段
+		replace := "${1}" + strings.ReplaceAll(strconv.Quote(c.Replacement), "$", "$$")
+		return c.maskString(c.field.ReplaceAllString(string(data), replace))
+	}
+	val = c.maskFields(val)
+	for _, path := range c.paths {
+		c.maskPath(val, path)
+	}
+	if res, err := json.Marshal(val); err == nil {
+		data = res
+	}
+	return c.maskString(string(data))
+}
+
+// maskFields 按字段名脱敏任意层级的字段
+func (c *maskConfig) maskFields(val interface{}) interface{} {
+	switch data := val.(type) {
+	case map[string]interface{}:
+		for key, item := range data {
+			if c.fields[strings.ToLower(key)] {
+				data[key] = c.Replacement
+				continue
+			}
+			data[key] = c.maskFields(item)
+		}
+	case []interface{}:
+		for key, item := range data {
+			data[key] = c.maskFields(item)
+		}
+	}
+	return val
+}
+
+// maskPath 按路径脱敏字段
+func (c *maskConfig) maskPath(val interface{}, path []string) {
+	if len(path) == 0 {
+		return
+	}
+	last := len(path) == 1
+	switch data := val.(type) {
+	case map[string]interface{}:
+		for key, item := range data {
+			if path[0] != "*" && path[0] != key {
+				continue
+			}
+			if last {
+				data[key] = c.Replacement
+				continue
+			}
+			c.maskPath(item, path[1:])
+		}
+	case []interface{}:
+		for key, item := range data {
+			// 数组可以使用*匹配元素，也可以省略
+			if path[0] != "*" {
+				c.maskPath(item, path)
+				continue
+			}
+			if last {
+				data[key] = c.Replacement
+				continue
+			}
+			c.maskPath(item, path[1:])
+		}
+	}
+}
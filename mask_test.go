@@ -0,0 +1,37 @@
+package core
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func TestMask(t *testing.T) {
+	v := viper.New()
+	v.Set("mask", map[string]interface{}{
+		"fields":   []string{"password", "user.phone", "items.*.id_card", "items.name", "tags.*"},
+		"headers":  []string{"authorization"},
+		"builtins": []string{"phone", "id_card"},
+	})
+	initMaskConfig(v)
+
+	cases := map[string]string{
+		`{"password":"123","user":{"phone":"x","name":"a"},"items":[{"id_card":"1"}]}`: `{"items":[{"id_card":"******"}],"password":"******","user":{"name":"a","phone":"******"}}`,
+		`{"items":[{"name":"a","id":1},{"name":"b","id":2}]}`:                          `{"items":[{"id":1,"name":"******"},{"id":2,"name":"******"}]}`,
+		`{"note":"13912345678 11010119900101123X"}`:                                    `{"note":"139****5678 1101**********123X"}`,
+		`{"password":"abc","user":{"phone":"1`:                                         `{"password":"******","user":{"phone":"******"`,
+		`phone=13812345678`:                                                            `phone=138****5678`,
+		`{"tags":["a","b"]}`:                                                           `{"tags":["******","******"]}`,
+	}
+	for data, want := range cases {
+		if got := MaskBody([]byte(data)); got != want {
+			t.Errorf("mask %s: got %s, want %s", data, got, want)
+		}
+	}
+
+	header := MaskHeader(http.Header{"Authorization": {"Bearer token"}, "Accept": {"*/*"}})
+	if header.Get("Authorization") != "******" || header.Get("Accept") != "*/*" {
+		t.Errorf("mask header: %v", header)
+	}
+}
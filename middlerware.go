@@ -2,43 +2,23 @@ package core
 
 import (
 	"context"
-	"fmt"
+	"errors"
 	"github.com/didip/tollbooth"
 	"github.com/google/uuid"
+	"github.com/spf13/viper"
 	"github.com/zeromicro/go-zero/core/load"
 	"go.uber.org/zap"
+	"io"
 	"net/http"
-	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
-func recovery() HandlerFunc {
-	return func(c *Context) {
-		defer func() {
-			if err := recover(); err != nil {
-				message := fmt.Sprintf("%s", err)
-				c.Log.Error(message, zap.Any("trace", tracePanicErr()))
-				c.Fail(http.StatusInternalServerError, "Internal Server Error")
-			}
-		}()
-		c.Next()
-	}
-}
-
-func tracePanicErr() []string {
-	var pcs [32]uintptr
-	n := runtime.Callers(3, pcs[:]) // skip first 3 caller
-	var arr []string
-	for _, pc := range pcs[:n] {
-		fn := runtime.FuncForPC(pc)
-		file, line := fn.FileLine(pc)
-		arr = append(arr, fmt.Sprintf("%s:%d", file, line))
-	}
-	return arr
-}
-
 func traceLog() HandlerFunc {
 	return func(ctx *Context) {
-		trace := ctx.GetHeader(TraceID)
+		trace := ctx.Request.Header.Get(TraceID)
 		if trace == "" {
 			trace = uuid.New().String()
 		}
@@ -52,6 +32,9 @@ func traceLog() HandlerFunc {
 // ipLimit ip限流
 func ipLimit() HandlerFunc {
 	max := globalConfig.GetFloat64("ip_limit.max")
+	if globalOptions.ipLimit != nil {
+		max = *globalOptions.ipLimit
+	}
 	limit := tollbooth.NewLimiter(max, nil)
 	return func(ctx *Context) {
 		if httpError := tollbooth.LimitByRequest(limit, ctx.Writer, ctx.Request); httpError != nil {
@@ -60,40 +43,252 @@ func ipLimit() HandlerFunc {
 	}
 }
 
-// CpuLoad 自适应降载
+// 路由降载优先级
+const (
+	PriorityNormal   = "normal"
+	PriorityHigh     = "high"     // 使用更高的cpu阈值，晚于普通路由降载
+	PriorityCritical = "critical" // 不参与降载，例如健康检查
+)
+
+type cpuLoadConfig struct {
+	Threshold  int64           `json:"threshold" mapstructure:"threshold"`     //cpu阈值，千分比，默认900
+	Window     time.Duration   `json:"window" mapstructure:"window"`           //统计窗口时长
+	Buckets    int             `json:"buckets" mapstructure:"buckets"`         //统计窗口的桶数量
+	RetryAfter time.Duration   `json:"retry_after" mapstructure:"retry_after"` //降载时建议客户端的重试时间
+	Priorities []routePriority `json:"priorities" mapstructure:"priorities"`   //路由优先级
+}
+
+type routePriority struct {
+	routeRule `mapstructure:",squash"`
+	Priority  string `json:"priority" mapstructure:"priority"`
+}
+
+func parseCpuLoadConfig(v *viper.Viper) cpuLoadConfig {
+	conf := cpuLoadConfig{}
+	if err := v.UnmarshalKey("cpu_load", &conf); err != nil {
+		panic("cpu_load 配置解析错误" + err.Error())
+	}
+	if conf.Threshold == 0 {
+		conf.Threshold = 900
+	}
+	if conf.Window == 0 {
+		conf.Window = 5 * time.Second
+	}
+	if conf.Buckets == 0 {
+		conf.Buckets = 50
+	}
+	if conf.RetryAfter == 0 {
+		conf.RetryAfter = time.Second
+	}
+	return conf
+}
+
+// newShedder 创建指定cpu阈值的降载器
+var newShedder = func(conf cpuLoadConfig, threshold int64) load.Shedder {
+	return load.NewAdaptiveShedder(
+		load.WithCpuThreshold(threshold),
+		load.WithWindow(conf.Window),
+		load.WithBuckets(conf.Buckets),
+	)
+}
+
+// cpuLoad 自适应降载
 func cpuLoad() HandlerFunc {
-	sd := load.NewAdaptiveShedder(load.WithCpuThreshold(0))
+	conf := parseCpuLoadConfig(globalConfig)
+	if globalOptions.cpuLoad != nil {
+		conf.Threshold = *globalOptions.cpuLoad
+	}
+	shedder := newShedder(conf, conf.Threshold)
+	// 高优先级的路由使用介于阈值与满载之间的阈值
+	highShedder := newShedder(conf, (conf.Threshold+1000)/2)
+	retryAfter := strconv.FormatInt(ceilSeconds(conf.RetryAfter), 10)
+
 	return func(ctx *Context) {
+		sd := shedder
+		for _, item := range conf.Priorities {
+			if !item.match(ctx) {
+				continue
+			}
+			switch item.Priority {
+			case PriorityCritical:
+				return
+			case PriorityHigh:
+				sd = highShedder
+			}
+			break
+		}
+
 		promise, err := sd.Allow()
 		if err != nil {
-			ctx.Fail(500, "系统繁忙，请稍后再试")
+			ctx.Log.Warn("request dropped by load shedding", zap.String("path", ctx.Path))
+			ctx.SetHeader("Retry-After", retryAfter)
+			ctx.Fail(http.StatusServiceUnavailable, "系统繁忙，请稍后再试")
+			return
 		}
-		promise.Pass()
+
+		failure := true // 处理过程中发生panic时按失败上报
+		defer func() {
+			if failure {
+				promise.Fail()
+			} else {
+				promise.Pass()
+			}
+		}()
+		ctx.Next()
+		failure = ctx.Writer.Status() >= http.StatusInternalServerError
 	}
 }
 
-// CpuLoad 自适应降载
+// requestTimeout 获取请求的超时时间，优先级为 路由配置 > 分组配置 > 全局配置
+func requestTimeout(ctx *Context) time.Duration {
+	for _, item := range globalSystemConfig.RouteTimeouts {
+		if item.match(ctx) {
+			return item.Timeout
+		}
+	}
+	if ctx.engine != nil {
+		var group *routerGroup
+		for _, item := range ctx.engine.groups {
+			if item.timeout == 0 || !strings.HasPrefix(ctx.Path, item.prefix) {
+				continue
+			}
+			if group == nil || len(item.prefix) > len(group.prefix) {
+				group = item
+			}
+		}
+		if group != nil {
+			return group.timeout
+		}
+	}
+	return globalSystemConfig.Timeout
+}
+
+// timeout 超时处理
+// 后续的处理在独立的协程中执行，响应先写入缓冲区，由当前协程决定最终输出处理结果还是超时响应，保证只输出一次
 func timeout() HandlerFunc {
 	return func(ctx *Context) {
-		called := make(chan bool)
-		uctx, cancel := context.WithTimeout(ctx.Context, globalSystemConfig.Timeout)
+		duration := requestTimeout(ctx)
+		if duration <= 0 {
+			return
+		}
+
+		uctx, cancel := context.WithTimeout(ctx.Context, duration)
 		defer cancel()
 
+		// 处理协程使用独立的副本，超时返回后两个协程不会同时修改同一个Context
+		buffer := newBufferWriter()
+		inner := *ctx
+		inner.Context = uctx
+		inner.Writer = buffer
+		inner.Request = ctx.Request.WithContext(uctx)
+
+		done := make(chan struct{})
+		panicChan := make(chan interface{}, 1)
 		go func() {
-			ctx.Next()
-			<-called
+			defer func() {
+				if err := recover(); err != nil {
+					// 在当前协程中记录堆栈，交由外层的recovery处理
+					if err != http.ErrAbortHandler {
+						err = &panicWithStack{value: err, stack: panicStack(loadRecoveryConfig().StackDepth)}
+					}
+					panicChan <- err
+				}
+			}()
+			inner.Next()
+			close(done)
 		}()
 
-		if globalSystemConfig.Timeout == 0 {
-			called <- true
-			return
-		}
-
+		// 当前请求后续的中间件已经由处理协程执行
+		ctx.Abort()
 		select {
+		case err := <-panicChan:
+			buffer.close()
+			panic(err)
+		case <-done:
+			buffer.flushTo(ctx.Writer)
+			// 处理完成后超时上下文已经取消，外层继续使用原有的上下文，只带回处理中设置的数据
+			ctx.Context = valueContext{Context: ctx.Context, values: inner.Context}
+			ctx.StatusCode = buffer.Status()
+			ctx.TraceID = inner.TraceID
+			ctx.Log = inner.Log
+			ctx.Config = inner.Config
 		case <-uctx.Done():
-			close(called)
-			ctx.Fail(500, "request timeout")
-		case called <- true:
+			buffer.close()
+			// 客户端主动断开时无需响应
+			if !errors.Is(uctx.Err(), context.DeadlineExceeded) {
+				return
+			}
+			ctx.Log.Warn("request timeout", zap.String("path", ctx.Path), zap.Duration("timeout", duration))
+			ctx.Fail(globalSystemConfig.TimeoutStatus, "request timeout")
+		}
+	}
+}
+
+// ErrBodyTooLarge 请求体超过限制
+var ErrBodyTooLarge = errors.New("http: request body too large")
+
+// maxBytesReader 限制请求体的读取长度，超出后返回 ErrBodyTooLarge
+type maxBytesReader struct {
+	io.ReadCloser
+	remain   int64
+	exceeded int32 // 超时处理时可能在其他协程中读取
+}
+
+func (r *maxBytesReader) Read(p []byte) (int, error) {
+	if atomic.LoadInt32(&r.exceeded) == 1 {
+		return 0, ErrBodyTooLarge
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// 多读取一个字节用于判断是否超出限制
+	if int64(len(p)) > r.remain+1 {
+		p = p[:r.remain+1]
+	}
+	n, err := r.ReadCloser.Read(p)
+	if int64(n) <= r.remain {
+		r.remain -= int64(n)
+		return n, err
+	}
+	n = int(r.remain)
+	r.remain = 0
+	atomic.StoreInt32(&r.exceeded, 1)
+	return n, ErrBodyTooLarge
+}
+
+// fail 处理函数未输出响应且请求体超出限制时补充413
+func (r *maxBytesReader) fail(ctx *Context) {
+	if !ctx.Writer.Written() && atomic.LoadInt32(&r.exceeded) == 1 {
+		ctx.SetHeader("Connection", "close")
+		ctx.Fail(http.StatusRequestEntityTooLarge, "request body too large")
+	}
+}
+
+// requestBodySize 按 指定路由 > 全局 的优先级获取请求体限制
+func requestBodySize(ctx *Context) int64 {
+	for _, item := range globalSystemConfig.RouteBodySizes {
+		if item.match(ctx) {
+			return item.MaxBodySize
+		}
+	}
+	return globalSystemConfig.MaxBodySize
+}
+
+// bodyLimit 限制请求体大小，声明的长度超出时直接返回413，否则在读取超出时返回 ErrBodyTooLarge
+func bodyLimit() HandlerFunc {
+	return func(ctx *Context) {
+		limit := requestBodySize(ctx)
+		if limit <= 0 || ctx.Request.Body == nil || ctx.Request.Body == http.NoBody {
+			return
+		}
+		if ctx.Request.ContentLength > limit {
+			ctx.SetHeader("Connection", "close")
+			ctx.Fail(http.StatusRequestEntityTooLarge, "request body too large")
+			return
 		}
+		reader := &maxBytesReader{ReadCloser: ctx.Request.Body, remain: limit}
+		ctx.Request.Body = reader
+		ctx.Next()
+		reader.fail(ctx)
 	}
 }
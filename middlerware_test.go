@@ -0,0 +1,297 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"github.com/spf13/viper"
+	"github.com/zeromicro/go-zero/core/load"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func serveTimeout(handler HandlerFunc, outer HandlerFunc) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ctx := newContext(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	ctx.handlers = []HandlerFunc{recovery(), outer, timeout(), handler}
+	ctx.Next()
+	return w
+}
+
+func TestTimeout(t *testing.T) {
+	globalSystemConfig.Timeout = 50 * time.Millisecond
+	globalSystemConfig.TimeoutStatus = http.StatusServiceUnavailable
+	defer func() { globalSystemConfig.Timeout = 0 }()
+
+	tests := []struct {
+		name    string
+		handler HandlerFunc
+		status  int
+		body    string
+	}{
+		{"normal", func(c *Context) {
+			c.SetValue(UserIDKey, "7")
+			c.String(http.StatusCreated, "ok")
+		}, http.StatusCreated, "ok"},
+		{"timeout", func(c *Context) {
+			<-c.Done()
+			c.SetHeader("X-Late", "1")
+			c.String(http.StatusOK, "late")
+		}, http.StatusServiceUnavailable, "request timeout"},
+		{"panic", func(c *Context) {
+			panic("boom")
+		}, http.StatusInternalServerError, "Internal Server Error"},
+	}
+	for _, item := range tests {
+		var userID string
+		var canceled bool
+		done := make(chan struct{})
+		w := serveTimeout(func(c *Context) {
+			defer close(done)
+			item.handler(c)
+		}, func(c *Context) {
+			c.Next()
+			userID, canceled = c.GetString(UserIDKey), c.Err() != nil
+		})
+		<-done
+		if w.Code != item.status || !strings.Contains(w.Body.String(), item.body) || w.Header().Get("X-Late") != "" {
+			t.Fatalf("%s: unexpected response %d %s", item.name, w.Code, w.Body.String())
+		}
+		if canceled {
+			t.Fatalf("%s: outer context should not be canceled", item.name)
+		}
+		if item.name == "normal" && userID != "7" {
+			t.Fatalf("%s: value set in handler is lost", item.name)
+		}
+	}
+}
+
+func TestTimeoutPanicStack(t *testing.T) {
+	globalSystemConfig.Timeout = time.Second
+	defer func() { globalSystemConfig.Timeout = 0 }()
+
+	var info *PanicInfo
+	SetRecoveryHandler(func(c *Context, p *PanicInfo) {
+		info = p
+		c.Fail(http.StatusInternalServerError, p.Message)
+	})
+	defer SetRecoveryHandler(nil)
+	serveTimeout(func(c *Context) { panic("boom") }, func(c *Context) {})
+	if info == nil || info.Message != "boom" || len(info.Stack) == 0 || !strings.Contains(info.Stack[0], "TestTimeoutPanicStack") {
+		t.Fatalf("panic stack should point to the handler: %+v", info)
+	}
+}
+
+func TestIPLimitOption(t *testing.T) {
+	v := viper.New()
+	v.Set("ip_limit", map[string]interface{}{"max": 100})
+	config, options := globalConfig, globalOptions
+	globalConfig, globalOptions = v, newOptions([]Option{WithIPLimit(1)})
+	defer func() { globalConfig, globalOptions = config, options }()
+
+	limit := ipLimit()
+	codes := make([]int, 2)
+	for i := range codes {
+		w := httptest.NewRecorder()
+		ctx := newContext(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		ctx.handlers = []HandlerFunc{limit}
+		ctx.Next()
+		codes[i] = w.Code
+	}
+	if codes[0] != http.StatusOK || codes[1] != http.StatusBadRequest {
+		t.Fatalf("option should override ip_limit.max, got %v", codes)
+	}
+	if v.GetFloat64("ip_limit.max") != 100 {
+		t.Fatal("option should not modify config")
+	}
+}
+
+func TestCpuThresholdOption(t *testing.T) {
+	v := viper.New()
+	v.Set("cpu_load", map[string]interface{}{"window": "1s", "priorities": []map[string]string{{"path": "/health", "priority": PriorityCritical}}})
+	config, options := globalConfig, globalOptions
+	globalConfig, globalOptions = v, newOptions([]Option{WithCpuThreshold(800)})
+	defer func() { globalConfig, globalOptions = config, options }()
+
+	cpuLoad()
+	conf := parseCpuLoadConfig(v)
+	if conf.Window != time.Second || len(conf.Priorities) != 1 {
+		t.Fatalf("option should not hide other cpu_load settings, got %+v", conf)
+	}
+	if conf.Threshold != 900 {
+		t.Fatal("option should not modify config")
+	}
+}
+
+// stubShedder 按阈值决定是否降载
+type stubShedder struct {
+	threshold  int64
+	overloaded map[int64]bool
+	passed     *int
+	failed     *int
+}
+
+func (s *stubShedder) Allow() (load.Promise, error) {
+	if s.overloaded[s.threshold] {
+		return nil, load.ErrServiceOverloaded
+	}
+	return s, nil
+}
+
+func (s *stubShedder) Pass() { *s.passed++ }
+
+func (s *stubShedder) Fail() { *s.failed++ }
+
+func TestCpuLoad(t *testing.T) {
+	v := viper.New()
+	v.Set("cpu_load", map[string]interface{}{
+		"threshold":   800,
+		"retry_after": "1500ms",
+		"priorities": []map[string]string{
+			{"path": "/health", "priority": PriorityCritical},
+			{"path": "/order", "priority": PriorityHigh},
+		},
+	})
+	config, options, shedder := globalConfig, globalOptions, newShedder
+	globalConfig, globalOptions = v, newOptions(nil)
+	defer func() { globalConfig, globalOptions, newShedder = config, options, shedder }()
+
+	tests := []struct {
+		name       string
+		path       string
+		overloaded map[int64]bool
+		status     int
+		passed     int
+		failed     int
+	}{
+		{"normal", "/user", nil, http.StatusOK, 1, 0},
+		{"normal shed", "/user", map[int64]bool{800: true}, http.StatusServiceUnavailable, 0, 0},
+		{"high uses higher threshold", "/order", map[int64]bool{800: true}, http.StatusOK, 1, 0},
+		{"high shed", "/order", map[int64]bool{800: true, 900: true}, http.StatusServiceUnavailable, 0, 0},
+		{"critical never shed", "/health", map[int64]bool{800: true, 900: true}, http.StatusOK, 0, 0},
+		{"server error reported", "/fail", nil, http.StatusInternalServerError, 0, 1},
+	}
+	for _, tt := range tests {
+		var passed, failed int
+		newShedder = func(_ cpuLoadConfig, threshold int64) load.Shedder {
+			return &stubShedder{threshold: threshold, overloaded: tt.overloaded, passed: &passed, failed: &failed}
+		}
+		w := httptest.NewRecorder()
+		ctx := newContext(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		ctx.handlers = []HandlerFunc{cpuLoad(), func(c *Context) {
+			if c.Path == "/fail" {
+				c.Fail(http.StatusInternalServerError, "fail")
+				return
+			}
+			c.String(http.StatusOK, "ok")
+		}}
+		ctx.Next()
+		if w.Code != tt.status || passed != tt.passed || failed != tt.failed {
+			t.Errorf("%s: got status %d passed %d failed %d", tt.name, w.Code, passed, failed)
+		}
+		retryAfter := ""
+		if tt.status == http.StatusServiceUnavailable {
+			retryAfter = "2"
+		}
+		if w.Header().Get("Retry-After") != retryAfter {
+			t.Errorf("%s: Retry-After = %q", tt.name, w.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestBodyLimit(t *testing.T) {
+	system := globalSystemConfig
+	globalSystemConfig.MaxBodySize = 16
+	globalSystemConfig.MaxMultipartMemory = 1 << 20
+	globalSystemConfig.RouteBodySizes = []routeBodySize{{routeRule: routeRule{Path: "/upload"}, MaxBodySize: 64}}
+	defer func() { globalSystemConfig = system }()
+
+	readBody := func(ctx *Context) {
+		body, err := ctx.Body()
+		if err != nil {
+			return
+		}
+		ctx.String(http.StatusOK, string(body))
+	}
+	multipartBody := func(size int) (io.Reader, string) {
+		buf := &bytes.Buffer{}
+		mw := multipart.NewWriter(buf)
+		fw, _ := mw.CreateFormFile("file", "a.txt")
+		fw.Write(bytes.Repeat([]byte("a"), size))
+		mw.Close()
+		return buf, mw.FormDataContentType()
+	}
+	readFile := func(ctx *Context) {
+		file, err := ctx.FormFile("file")
+		if err != nil {
+			if !errors.Is(err, ErrBodyTooLarge) {
+				ctx.Fail(http.StatusBadRequest, err.Error())
+			}
+			return
+		}
+		ctx.String(http.StatusOK, strconv.FormatInt(file.Size, 10))
+	}
+
+	tests := []struct {
+		name    string
+		path    string
+		size    int
+		chunked bool
+		timeout time.Duration
+		handler HandlerFunc
+		status  int
+	}{
+		{"within limit", "/", 16, false, 0, readBody, http.StatusOK},
+		{"content length", "/", 17, false, 0, func(ctx *Context) { t.Error("handler should not be called") }, http.StatusRequestEntityTooLarge},
+		{"read overflow", "/", 17, true, 0, readBody, http.StatusRequestEntityTooLarge},
+		{"read overflow with timeout", "/", 17, true, time.Second, readBody, http.StatusRequestEntityTooLarge},
+		{"route limit", "/upload", 64, true, 0, readBody, http.StatusOK},
+		{"route limit overflow", "/upload", 65, true, 0, readBody, http.StatusRequestEntityTooLarge},
+		{"handler response kept", "/", 17, true, 0, func(ctx *Context) {
+			ctx.Body()
+			ctx.Fail(http.StatusBadRequest, "bad request")
+		}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		globalSystemConfig.Timeout = tt.timeout
+		r := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(bytes.Repeat([]byte("a"), tt.size)))
+		if tt.chunked {
+			r.ContentLength = -1
+		}
+		w := httptest.NewRecorder()
+		ctx := newContext(w, r)
+		ctx.handlers = []HandlerFunc{bodyLimit(), timeout(), tt.handler}
+		ctx.Next()
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if tt.status == http.StatusRequestEntityTooLarge && w.Header().Get("Connection") != "close" {
+			t.Errorf("%s: connection should be closed", tt.name)
+		}
+	}
+	globalSystemConfig.Timeout = 0
+
+	globalSystemConfig.RouteBodySizes = []routeBodySize{{routeRule: routeRule{Path: "/upload"}, MaxBodySize: 1024}}
+	for _, size := range []int{512, 2048} {
+		body, contentType := multipartBody(size)
+		r := httptest.NewRequest(http.MethodPost, "/upload", body)
+		r.Header.Set("Content-Type", contentType)
+		r.ContentLength = -1
+		w := httptest.NewRecorder()
+		ctx := newContext(w, r)
+		ctx.handlers = []HandlerFunc{bodyLimit(), readFile}
+		ctx.Next()
+		want := http.StatusOK
+		if size > 1024 {
+			want = http.StatusRequestEntityTooLarge
+		}
+		if w.Code != want {
+			t.Errorf("multipart %d: status = %d, want %d, body %s", size, w.Code, want, w.Body.String())
+		}
+	}
+}
@@ -10,54 +10,54 @@ import (
 )
 
 // zap 适配gorm 日志
-type log struct {
+type mysqlLog struct {
 	logger        *zap.Logger
 	LogLevel      logger.LogLevel
 	SlowThreshold time.Duration
 }
 
 func newMysqlLog(conf mysqlConfig) logger.Interface {
-	return &log{
+	return &mysqlLog{
 		logger:        globalLog,
 		LogLevel:      logger.LogLevel(conf.Level),
 		SlowThreshold: time.Duration(conf.SlowThreshold),
 	}
 }
 
-func (l *log) Log(ctx context.Context) *zap.Logger {
+func (l *mysqlLog) Log(ctx context.Context) *zap.Logger {
 	id, _ := ctx.Value(TraceID).(string)
 	return l.logger.With(zap.Any(TraceID, id))
 }
 
 // LogMode log mode
-func (l *log) LogMode(level logger.LogLevel) logger.Interface {
+func (l *mysqlLog) LogMode(level logger.LogLevel) logger.Interface {
 	l.LogLevel = level
 	return l
 }
 
 // Info print info
-func (l log) Info(ctx context.Context, msg string, data ...interface{}) {
+func (l mysqlLog) Info(ctx context.Context, msg string, data ...interface{}) {
 	if l.LogLevel >= logger.Info {
 		l.Log(ctx).Info("SQL信息", getSqlInfo("", fmt.Sprintf(msg, data...), 0, 0, false)...)
 	}
 }
 
 // Warn print warn messages
-func (l log) Warn(ctx context.Context, msg string, data ...interface{}) {
+func (l mysqlLog) Warn(ctx context.Context, msg string, data ...interface{}) {
 	if l.LogLevel >= logger.Warn {
 		l.Log(ctx).Info("SQL告警", getSqlInfo("", fmt.Sprintf(msg, data...), 0, 0, false)...)
 	}
 }
 
 // Error print error messages
-func (l log) Error(ctx context.Context, msg string, data ...interface{}) {
+func (l mysqlLog) Error(ctx context.Context, msg string, data ...interface{}) {
 	if l.LogLevel >= logger.Error {
 		l.Log(ctx).Info("SQL错误", getSqlInfo("", fmt.Sprintf(msg, data...), 0, 0, false)...)
 	}
 }
 
 // Trace print sql message
-func (l log) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+func (l mysqlLog) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
 	if l.LogLevel <= logger.Silent {
 		return
 	}
@@ -67,9 +67,11 @@ func (l log) Trace(ctx context.Context, begin time.Time, fc func() (string, int6
 	case err != nil && l.LogLevel >= logger.Error && (!errors.Is(err, logger.ErrRecordNotFound)):
 		sql, rows := fc()
 		l.Log(ctx).Info("SQL错误", getSqlInfo(err.Error(), sql, rows, costTime, false)...)
+		alertSQL(ctx, AlertSQLError, sql, rows, costTime, err)
 	case elapsed > l.SlowThreshold && l.SlowThreshold != 0 && l.LogLevel >= logger.Warn:
 		sql, rows := fc()
 		l.Log(ctx).Info("SQL告警", getSqlInfo("", sql, rows, costTime, true)...)
+		alertSQL(ctx, AlertSlowSQL, sql, rows, costTime, nil)
 	case l.LogLevel == logger.Info:
 		sql, rows := fc()
 		l.Log(ctx).Info("SQL信息", getSqlInfo("", sql, rows, costTime, false)...)
@@ -0,0 +1,165 @@
+package core
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultMiddlewares 默认中间件及其执行顺序
+var defaultMiddlewares = []string{"trace_log", "access_log", "recovery", "maintenance", "body_limit", "body_log", "cors", "timeout", "cpu_load", "ip_limit"}
+
+// MiddlewareFactory 中间件构造函数，在服务初始化完成后调用
+type MiddlewareFactory func() HandlerFunc
+
+var (
+	middlewares = map[string]MiddlewareFactory{
+		"trace_log":   traceLog,
+		"recovery":    recovery,
+		"timeout":     timeout,
+		"body_limit":  bodyLimit,
+		"cpu_load":    cpuLoad,
+		"ip_limit":    ipLimit,
+		"breaker":     Breaker,
+		"rate_limit":  RateLimit,
+		"cors":        Cors,
+		"jwt":         JWT,
+		"signature":   Signature,
+		"rbac":        RBAC,
+		"compress":    Compress,
+		"access_log":  AccessLog,
+		"body_log":    BodyLog,
+		"secure":      Secure,
+		"csrf":        CSRF,
+		"idempotency": Idempotency,
+		"cache":       Cache,
+		"bulkhead":    Bulkhead,
+		"session":     Sessions,
+		"maintenance": Maintenance,
+		"fault":       Fault,
+	}
+	middlewareMutex sync.RWMutex
+)
+
+// RegisterMiddleware 注册具名中间件，注册后可以在 middleware 配置中按名称启用
+func RegisterMiddleware(name string, factory MiddlewareFactory) {
+	middlewareMutex.Lock()
+	defer middlewareMutex.Unlock()
+	middlewares[name] = factory
+}
+
+type middlewareConfig struct {
+	Defaults []string `json:"defaults" mapstructure:"defaults"` //默认中间件及顺序
+	Disable  []string `json:"disable" mapstructure:"disable"`   //禁用的中间件
+}
+
+// Option 服务初始化选项
+type Option func(o *engineOptions)
+
+type engineOptions struct {
+	middlewares []string
+	disable     []string
+	timeout     *time.Duration
+	maxBodySize *int64
+	ipLimit     *float64
+	cpuLoad     *int64
+}
+
+// globalOptions 服务初始化时的选项，中间件创建时使用其覆盖解析后的配置
+var globalOptions = &engineOptions{}
+
+func newOptions(opts []Option) *engineOptions {
+	o := &engineOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// WithMiddlewares 指定默认中间件及顺序，优先级高于配置
+func WithMiddlewares(names ...string) Option {
+	return func(o *engineOptions) {
+		o.middlewares = names
+	}
+}
+
+// WithoutMiddlewares 禁用指定的默认中间件
+func WithoutMiddlewares(names ...string) Option {
+	return func(o *engineOptions) {
+		o.disable = append(o.disable, names...)
+	}
+}
+
+// WithTimeout 设置全局超时时间，覆盖 system.timeout
+func WithTimeout(timeout time.Duration) Option {
+	return func(o *engineOptions) {
+		o.timeout = &timeout
+	}
+}
+
+// WithMaxBodySize 设置全局请求体最大字节数，覆盖 system.max_body_size
+func WithMaxBodySize(size int64) Option {
+	return func(o *engineOptions) {
+		o.maxBodySize = &size
+	}
+}
+
+// WithIPLimit 设置ip限流的每秒请求数，覆盖 ip_limit.max
+func WithIPLimit(max float64) Option {
+	return func(o *engineOptions) {
+		o.ipLimit = &max
+	}
+}
+
+// WithCpuThreshold 设置自适应降载的cpu阈值，千分比，覆盖 cpu_load.threshold
+func WithCpuThreshold(threshold int64) Option {
+	return func(o *engineOptions) {
+		o.cpuLoad = &threshold
+	}
+}
+
+// apply 使用选项覆盖配置中的参数，中间件相关的参数在中间件创建时覆盖，不修改配置本身
+func (o *engineOptions) apply() {
+	globalOptions = o
+	if o.timeout != nil {
+		globalSystemConfig.Timeout = *o.timeout
+	}
+	if o.maxBodySize != nil {
+		globalSystemConfig.MaxBodySize = *o.maxBodySize
+	}
+}
+
+// defaultMiddlewares 按 选项 > 配置 > 内置 的优先级获取默认中间件
+func (o *engineOptions) defaultMiddlewares() []HandlerFunc {
+	conf := middlewareConfig{}
+	if err := globalConfig.UnmarshalKey("middleware", &conf); err != nil {
+		panic("middleware 配置解析错误" + err.Error())
+	}
+
+	names := defaultMiddlewares
+	if conf.Defaults != nil {
+		names = conf.Defaults
+	}
+	if o.middlewares != nil {
+		names = o.middlewares
+	}
+
+	disable := map[string]bool{}
+	for _, name := range append(conf.Disable, o.disable...) {
+		disable[name] = true
+	}
+
+	middlewareMutex.RLock()
+	defer middlewareMutex.RUnlock()
+	var handlers []HandlerFunc
+	for _, name := range names {
+		if disable[name] {
+			continue
+		}
+		factory, ok := middlewares[name]
+		if !ok {
+			panic("middleware not found:" + name)
+		}
+		handlers = append(handlers, factory())
+	}
+	return handlers
+}
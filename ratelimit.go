@@ -0,0 +1,388 @@
+package core
+
+import (
+	"context"
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 限流算法
+const (
+	TokenBucket   = "token_bucket"
+	SlidingWindow = "sliding_window"
+)
+
+type rateLimitConfig struct {
+	Enable   bool              `json:"enable" mapstructure:"enable"`     //是否启用限流
+	Store    string            `json:"store" mapstructure:"store"`       //限流数据存储 local/redis
+	Prefix   string            `json:"prefix" mapstructure:"prefix"`     //redis key前缀
+	Policies []rateLimitPolicy `json:"policies" mapstructure:"policies"` //限流策略，按顺序匹配第一个
+}
+
+type rateLimitPolicy struct {
+	routeRule `mapstructure:",squash"`
+	Algorithm string        `json:"algorithm" mapstructure:"algorithm"` //限流算法 token_bucket/sliding_window
+	Key       string        `json:"key" mapstructure:"key"`             //限流维度 ip/user/header:xxx/自定义
+	Rate      int64         `json:"rate" mapstructure:"rate"`           //周期内允许的请求数
+	Period    time.Duration `json:"period" mapstructure:"period"`       //周期
+	Burst     int64         `json:"burst" mapstructure:"burst"`         //令牌桶容量
+}
+
+var rateLimitConf atomic.Value
+
+func initRateLimitConfig(v *viper.Viper) {
+	conf, err := parseRateLimitConfig(v)
+	storeConfig("rate_limit", &rateLimitConf, conf, err)
+}
+
+func parseRateLimitConfig(v *viper.Viper) (rateLimitConfig, error) {
+	conf := rateLimitConfig{}
+	if err := v.UnmarshalKey("rate_limit", &conf); err != nil {
+		return rateLimitConfig{}, err
+	}
+	if conf.Store == "" {
+		conf.Store = "local"
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = "rate_limit"
+	}
+	for key, item := range conf.Policies {
+		if item.Algorithm == "" {
+			conf.Policies[key].Algorithm = TokenBucket
+		}
+		if item.Key == "" {
+			conf.Policies[key].Key = "ip"
+		}
+		if item.Period == 0 {
+			conf.Policies[key].Period = time.Second
+		}
+		if item.Burst == 0 {
+			conf.Policies[key].Burst = item.Rate
+		}
+	}
+	return conf, nil
+}
+
+func loadRateLimitConfig() rateLimitConfig {
+	conf, _ := rateLimitConf.Load().(rateLimitConfig)
+	return conf
+}
+
+// RateLimitKeyFunc 自定义限流维度
+type RateLimitKeyFunc func(ctx *Context) string
+
+var (
+	rateLimitKeys      = map[string]RateLimitKeyFunc{}
+	rateLimitKeysMutex sync.RWMutex
+)
+
+// RegisterRateLimitKey 注册自定义限流维度，在策略中通过 key 字段引用
+func RegisterRateLimitKey(name string, fn RateLimitKeyFunc) {
+	rateLimitKeysMutex.Lock()
+	defer rateLimitKeysMutex.Unlock()
+	rateLimitKeys[name] = fn
+}
+
+// rateLimitKey 根据策略获取限流维度的值
+func rateLimitKey(ctx *Context, policy rateLimitPolicy) string {
+	switch {
+	case policy.Key == "ip":
+		return ctx.ClientIP()
+	case policy.Key == "user":
+		if id := ctx.GetString(UserIDKey); id != "" {
+			return id
+		}
+		return ctx.ClientIP()
+	case strings.HasPrefix(policy.Key, "header:"):
+		return ctx.Request.Header.Get(strings.TrimPrefix(policy.Key, "header:"))
+	}
+
+	rateLimitKeysMutex.RLock()
+	fn, ok := rateLimitKeys[policy.Key]
+	rateLimitKeysMutex.RUnlock()
+	if !ok {
+		return ctx.ClientIP()
+	}
+	return fn(ctx)
+}
+
+type rateLimitResult struct {
+	allowed    bool
+	limit      int64
+	remaining  int64
+	reset      time.Duration // 额度完全恢复的时间
+	retryAfter time.Duration // 被拒绝时建议的重试时间
+}
+
+type rateLimitStore interface {
+	take(ctx context.Context, key string, policy rateLimitPolicy) (rateLimitResult, error)
+}
+
+// RateLimit 限流，按配置中的策略对路由进行限流，支持本地及redis分布式限流
+func RateLimit() HandlerFunc {
+	local := newLocalRateLimitStore()
+	return func(ctx *Context) {
+		conf := loadRateLimitConfig()
+		if !conf.Enable {
+			return
+		}
+
+		var policy *rateLimitPolicy
+		for key, item := range conf.Policies {
+			if item.match(ctx) {
+				policy = &conf.Policies[key]
+				break
+			}
+		}
+		if policy == nil || policy.Rate <= 0 {
+			return
+		}
+
+		var store rateLimitStore = local
+		if conf.Store == "redis" && globalRedisConnect != nil {
+			store = &redisRateLimitStore{client: globalRedisConnect}
+		}
+
+		route := ctx.Pattern
+		if route == "" {
+			route = ctx.Path
+		}
+		key := strings.Join([]string{conf.Prefix, policy.Algorithm, ctx.Method, route, rateLimitKey(ctx, *policy)}, ":")
+		res, err := store.take(ctx, key, *policy)
+		if err != nil {
+			// 限流存储不可用时放行，避免影响正常业务
+			ctx.Log.Error("rate limit error", zap.Error(err))
+			return
+		}
+
+		ctx.SetHeader("X-RateLimit-Limit", strconv.FormatInt(res.limit, 10))
+		ctx.SetHeader("X-RateLimit-Remaining", strconv.FormatInt(res.remaining, 10))
+		ctx.SetHeader("X-RateLimit-Reset", strconv.FormatInt(ceilSeconds(res.reset), 10))
+		if !res.allowed {
+			ctx.SetHeader("Retry-After", strconv.FormatInt(ceilSeconds(res.retryAfter), 10))
+			ctx.Fail(http.StatusTooManyRequests, "请求过于频繁，请稍后再试")
+		}
+	}
+}
+
+func ceilSeconds(d time.Duration) int64 {
+	if d <= 0 {
+		return 0
+	}
+	return int64(math.Ceil(d.Seconds()))
+}
+
+// tokenBucketResult 根据桶内剩余令牌计算限流结果
+func tokenBucketResult(allowed bool, tokens float64, policy rateLimitPolicy) rateLimitResult {
+	interval := float64(policy.Period) / float64(policy.Rate) // 生成一个令牌的时间
+	res := rateLimitResult{
+		allowed:   allowed,
+		limit:     policy.Burst,
+		remaining: int64(tokens),
+		reset:     time.Duration((float64(policy.Burst) - tokens) * interval),
+	}
+	if !allowed {
+		res.retryAfter = time.Duration((1 - tokens) * interval)
+	}
+	return res
+}
+
+type localBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type localWindow struct {
+	start    time.Time // 当前窗口开始时间
+	current  int64
+	previous int64
+}
+
+type localRateLimitStore struct {
+	mu      sync.Mutex
+	buckets map[string]*localBucket
+	windows map[string]*localWindow
+	cleaned time.Time
+}
+
+func newLocalRateLimitStore() *localRateLimitStore {
+	return &localRateLimitStore{
+		buckets: map[string]*localBucket{},
+		windows: map[string]*localWindow{},
+		cleaned: time.Now(),
+	}
+}
+
+func (s *localRateLimitStore) take(_ context.Context, key string, policy rateLimitPolicy) (rateLimitResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.clean(now)
+	if policy.Algorithm == SlidingWindow {
+		return s.window(now, key, policy), nil
+	}
+	return s.bucket(now, key, policy), nil
+}
+
+func (s *localRateLimitStore) bucket(now time.Time, key string, policy rateLimitPolicy) rateLimitResult {
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &localBucket{tokens: float64(policy.Burst), last: now}
+		s.buckets[key] = b
+	}
+	b.tokens += float64(now.Sub(b.last)) * float64(policy.Rate) / float64(policy.Period)
+	b.tokens = math.Min(b.tokens, float64(policy.Burst))
+	b.last = now
+
+	allowed := b.tokens >= 1
+	if allowed {
+		b.tokens--
+	}
+	return tokenBucketResult(allowed, b.tokens, policy)
+}
+
+// window 滑动窗口，使用前后两个固定窗口按时间加权估算窗口内的请求数
+func (s *localRateLimitStore) window(now time.Time, key string, policy rateLimitPolicy) rateLimitResult {
+	start := now.Truncate(policy.Period)
+	w, ok := s.windows[key]
+	if !ok {
+		w = &localWindow{start: start}
+		s.windows[key] = w
+	}
+	switch {
+	case w.start.Equal(start):
+	case w.start.Add(policy.Period).Equal(start):
+		w.previous, w.current, w.start = w.current, 0, start
+	default:
+		w.previous, w.current, w.start = 0, 0, start
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(policy.Period)
+	count := int64(float64(w.previous)*weight) + w.current
+
+	res := rateLimitResult{limit: policy.Rate, reset: policy.Period - elapsed}
+	if count < policy.Rate {
+		w.current++
+		res.allowed = true
+		res.remaining = policy.Rate - count - 1
+		return res
+	}
+	res.retryAfter = policy.Period - elapsed
+	return res
+}
+
+// clean 定期清理长时间未使用的限流数据
+func (s *localRateLimitStore) clean(now time.Time) {
+	if now.Sub(s.cleaned) < time.Minute {
+		return
+	}
+	s.cleaned = now
+	for key, item := range s.buckets {
+		if now.Sub(item.last) > 10*time.Minute {
+			delete(s.buckets, key)
+		}
+	}
+	for key, item := range s.windows {
+		if now.Sub(item.start) > 10*time.Minute {
+			delete(s.windows, key)
+		}
+	}
+}
+
+// 令牌桶，使用redis服务器时间计算令牌的生成
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local period = tonumber(ARGV[3])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+local data = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil then
+	tokens = burst
+	ts = now
+end
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate / period)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", now)
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst * period / rate) + 1000)
+return {allowed, tostring(tokens)}
+`)
+
+// 滑动窗口，使用有序集合记录窗口内的每次请求
+var slidingWindowScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000 + math.floor(tonumber(time[2]) / 1000)
+redis.call("ZREMRANGEBYSCORE", KEYS[1], 0, now - period)
+local count = redis.call("ZCARD", KEYS[1])
+local allowed = 0
+if count < limit then
+	redis.call("ZADD", KEYS[1], now, ARGV[3])
+	count = count + 1
+	allowed = 1
+end
+redis.call("PEXPIRE", KEYS[1], period)
+local reset = period
+local oldest = redis.call("ZRANGE", KEYS[1], 0, 0, "WITHSCORES")
+if oldest[2] then
+	reset = tonumber(oldest[2]) + period - now
+end
+return {allowed, count, reset}
+`)
+
+type redisRateLimitStore struct {
+	client *redis.Client
+}
+
+func (s *redisRateLimitStore) take(ctx context.Context, key string, policy rateLimitPolicy) (rateLimitResult, error) {
+	if policy.Algorithm == SlidingWindow {
+		return s.window(ctx, key, policy)
+	}
+	return s.bucket(ctx, key, policy)
+}
+
+func (s *redisRateLimitStore) bucket(ctx context.Context, key string, policy rateLimitPolicy) (rateLimitResult, error) {
+	res, err := tokenBucketScript.Run(ctx, s.client, []string{key},
+		policy.Rate, policy.Burst, policy.Period.Milliseconds()).Slice()
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+	allowed, _ := res[0].(int64)
+	tokens, _ := strconv.ParseFloat(res[1].(string), 64)
+	return tokenBucketResult(allowed == 1, tokens, policy), nil
+}
+
+func (s *redisRateLimitStore) window(ctx context.Context, key string, policy rateLimitPolicy) (rateLimitResult, error) {
+	res, err := slidingWindowScript.Run(ctx, s.client, []string{key},
+		policy.Rate, policy.Period.Milliseconds(), uuid.New().String()).Int64Slice()
+	if err != nil {
+		return rateLimitResult{}, err
+	}
+	result := rateLimitResult{
+		allowed:   res[0] == 1,
+		limit:     policy.Rate,
+		remaining: policy.Rate - res[1],
+		reset:     time.Duration(res[2]) * time.Millisecond,
+	}
+	if !result.allowed {
+		result.retryAfter = result.reset
+	}
+	return result, nil
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRateLimit(t *testing.T) {
+	tests := []struct {
+		name      string
+		policy    rateLimitPolicy
+		requests  int
+		allowed   int
+		limit     string
+		remaining []string
+	}{
+		{
+			name:      "token bucket",
+			policy:    rateLimitPolicy{Algorithm: TokenBucket, Rate: 3, Burst: 3, Period: time.Hour},
+			requests:  5,
+			allowed:   3,
+			limit:     "3",
+			remaining: []string{"2", "1", "0", "0", "0"},
+		},
+		{
+			name:      "token bucket burst",
+			policy:    rateLimitPolicy{Algorithm: TokenBucket, Rate: 1, Burst: 2, Period: time.Hour},
+			requests:  3,
+			allowed:   2,
+			limit:     "2",
+			remaining: []string{"1", "0", "0"},
+		},
+		{
+			name:      "sliding window",
+			policy:    rateLimitPolicy{Algorithm: SlidingWindow, Rate: 2, Period: time.Hour},
+			requests:  4,
+			allowed:   2,
+			limit:     "2",
+			remaining: []string{"1", "0", "0", "0"},
+		},
+	}
+	for _, tt := range tests {
+		tt.policy.Key = "ip"
+		rateLimitConf.Store(rateLimitConfig{Enable: true, Store: "local", Prefix: "rate_limit", Policies: []rateLimitPolicy{tt.policy}})
+		limit := RateLimit()
+		allowed := 0
+		for i := 0; i < tt.requests; i++ {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodGet, "/order", nil)
+			ctx := newContext(w, r)
+			ctx.handlers = []HandlerFunc{limit, func(ctx *Context) { ctx.String(http.StatusOK, "ok") }}
+			ctx.Next()
+
+			if w.Code == http.StatusOK {
+				allowed++
+			} else if w.Code != http.StatusTooManyRequests {
+				t.Fatalf("%s: unexpected status %d", tt.name, w.Code)
+			} else if w.Header().Get("Retry-After") == "" {
+				t.Errorf("%s: rejected request should have Retry-After", tt.name)
+			}
+			if got := w.Header().Get("X-RateLimit-Limit"); got != tt.limit {
+				t.Errorf("%s: X-RateLimit-Limit = %s, want %s", tt.name, got, tt.limit)
+			}
+			if got := w.Header().Get("X-RateLimit-Remaining"); got != tt.remaining[i] {
+				t.Errorf("%s: request %d X-RateLimit-Remaining = %s, want %s", tt.name, i, got, tt.remaining[i])
+			}
+			if w.Header().Get("X-RateLimit-Reset") == "" {
+				t.Errorf("%s: missing X-RateLimit-Reset", tt.name)
+			}
+		}
+		if allowed != tt.allowed {
+			t.Errorf("%s: allowed %d requests, want %d", tt.name, allowed, tt.allowed)
+		}
+	}
+	rateLimitConf.Store(rateLimitConfig{})
+}
+
+func TestRateLimitKeyByIP(t *testing.T) {
+	rateLimitConf.Store(rateLimitConfig{Enable: true, Store: "local", Prefix: "rate_limit", Policies: []rateLimitPolicy{
+		{Algorithm: TokenBucket, Key: "ip", Rate: 1, Burst: 1, Period: time.Hour},
+	}})
+	defer rateLimitConf.Store(rateLimitConfig{})
+	limit := RateLimit()
+	serve := func(remote, forwarded string) int {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/order", nil)
+		r.RemoteAddr = remote
+		r.Header.Set("X-Forwarded-For", forwarded)
+		ctx := newContext(w, r)
+		ctx.handlers = []HandlerFunc{limit}
+		ctx.Next()
+		return w.Code
+	}
+	if serve("1.1.1.1:1000", "2.2.2.2") != http.StatusOK {
+		t.Fatal("first request should be allowed")
+	}
+	// 伪造 X-Forwarded-For 不能绕过限流
+	if serve("1.1.1.1:1000", "3.3.3.3") != http.StatusTooManyRequests {
+		t.Fatal("spoofed X-Forwarded-For should not bypass rate limit")
+	}
+	if serve("4.4.4.4:1000", "") != http.StatusOK {
+		t.Fatal("other client should be allowed")
+	}
+}
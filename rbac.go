@@ -0,0 +1,281 @@
+package core
+
+import (
+	"errors"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RolesKey 当前用户角色在上下文中的key，未设置时从jwt声明中获取
+var RolesKey = "roles"
+
+type rbacPermission struct {
+	Action     string            `json:"action" mapstructure:"action"`         //操作，路由校验时为请求方法，* 匹配所有
+	Resource   string            `json:"resource" mapstructure:"resource"`     //资源，路由校验时为注册的路由，以*结尾时按前缀匹配
+	Conditions map[string]string `json:"conditions" mapstructure:"conditions"` //属性条件，与jwt声明中的属性比较
+	Condition  string            `json:"condition" mapstructure:"condition"`   //自定义条件，通过 RegisterPolicyCondition 注册
+}
+
+type rbacRole struct {
+	Name        string           `json:"name" mapstructure:"name"`
+	Inherits    []string         `json:"inherits" mapstructure:"inherits"` //继承的角色
+	Permissions []rbacPermission `json:"permissions" mapstructure:"permissions"`
+}
+
+type rbacConfig struct {
+	Enable    bool          `json:"enable" mapstructure:"enable"`
+	Source    string        `json:"source" mapstructure:"source"`         //策略来源 config/mysql
+	DB        string        `json:"db" mapstructure:"db"`                 //策略表所在的数据库名字
+	Table     string        `json:"table" mapstructure:"table"`           //策略表名
+	Refresh   time.Duration `json:"refresh" mapstructure:"refresh"`       //从数据库刷新策略的间隔
+	RoleClaim string        `json:"role_claim" mapstructure:"role_claim"` //jwt声明中角色所在的字段
+	Roles     []rbacRole    `json:"roles" mapstructure:"roles"`
+}
+
+// rbacRow 策略表的一行，inherit不为空时表示角色继承，否则表示一条权限
+type rbacRow struct {
+	Role     string `gorm:"column:role"`
+	Inherit  string `gorm:"column:inherit"`
+	Action   string `gorm:"column:action"`
+	Resource string `gorm:"column:resource"`
+}
+
+// rbacPolicy 展开继承关系后的角色权限
+type rbacPolicy map[string][]rbacPermission
+
+// PolicyCondition 自定义的权限条件
+type PolicyCondition func(ctx *Context, action, resource string) bool
+
+var (
+	rbacConf         atomic.Value
+	rbacPolicyValue  atomic.Value
+	rbacRefreshOnce  sync.Once
+	rbacReload       = make(chan struct{}, 1)
+	policyConditions = map[string]PolicyCondition{}
+	conditionMutex   sync.RWMutex
+)
+
+// RegisterPolicyCondition 注册自定义的权限条件，在权限的 condition 字段中引用
+func RegisterPolicyCondition(name string, fn PolicyCondition) {
+	conditionMutex.Lock()
+	defer conditionMutex.Unlock()
+	policyConditions[name] = fn
+}
+
+func initRbacConfig(v *viper.Viper) {
+	conf, err := parseRbacConfig(v)
+	if !storeConfig("rbac", &rbacConf, conf, err) {
+		return
+	}
+
+	if conf.Source != "mysql" {
+		rbacPolicyValue.Store(newRbacPolicy(conf.Roles))
+		return
+	}
+	// 策略只在后台协程中加载及定时刷新，配置变更时立即重新加载，加载完成前沿用原有的策略
+	if conf.Enable {
+		rbacRefreshOnce.Do(func() {
+			go refreshRbacPolicy()
+		})
+		select {
+		case rbacReload <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func parseRbacConfig(v *viper.Viper) (rbacConfig, error) {
+	conf := rbacConfig{}
+	if err := v.UnmarshalKey("rbac", &conf); err != nil {
+		return rbacConfig{}, err
+	}
+	if conf.Source == "" {
+		conf.Source = "config"
+	}
+	if conf.Table == "" {
+		conf.Table = "rbac_policy"
+	}
+	if conf.Refresh == 0 {
+		conf.Refresh = time.Minute
+	}
+	if conf.RoleClaim == "" {
+		conf.RoleClaim = "roles"
+	}
+	return conf, nil
+}
+
+func loadRbacConfig() rbacConfig {
+	conf, _ := rbacConf.Load().(rbacConfig)
+	return conf
+}
+
+func loadRbacPolicy() rbacPolicy {
+	policy, _ := rbacPolicyValue.Load().(rbacPolicy)
+	return policy
+}
+
+// refreshRbacPolicy 定时从数据库刷新策略，数据库在配置之后初始化，首次加载成功前每秒重试
+func refreshRbacPolicy() {
+	loaded := false
+	for {
+		conf := loadRbacConfig()
+		wait := conf.Refresh
+		if conf.Source == "mysql" && conf.Enable {
+			if err := loadRbacPolicyFromMysql(); err != nil {
+				if globalLog != nil {
+					globalLog.Error("rbac policy refresh fail", zap.Error(err))
+				}
+			} else {
+				loaded = true
+			}
+			if !loaded && wait > time.Second {
+				wait = time.Second
+			}
+		}
+		select {
+		case <-time.After(wait):
+		case <-rbacReload:
+		}
+	}
+}
+
+// loadRbacPolicyFromMysql 从策略表加载，加载失败时沿用原有的策略
+func loadRbacPolicyFromMysql() error {
+	conf := loadRbacConfig()
+	db, ok := globalMysqlConnects[conf.DB]
+	if !ok {
+		return errors.New("rbac mysql not found:" + conf.DB)
+	}
+	var rows []rbacRow
+	if err := db.Table(conf.Table).Find(&rows).Error; err != nil {
+		return err
+	}
+
+	roles := map[string]*rbacRole{}
+	var names []string
+	for _, row := range rows {
+		role, ok := roles[row.Role]
+		if !ok {
+			role = &rbacRole{Name: row.Role}
+			roles[row.Role] = role
+			names = append(names, row.Role)
+		}
+		if row.Inherit != "" {
+			role.Inherits = append(role.Inherits, row.Inherit)
+			continue
+		}
+		role.Permissions = append(role.Permissions, rbacPermission{Action: row.Action, Resource: row.Resource})
+	}
+	list := make([]rbacRole, 0, len(names))
+	for _, name := range names {
+		list = append(list, *roles[name])
+	}
+	rbacPolicyValue.Store(newRbacPolicy(list))
+	return nil
+}
+
+// newRbacPolicy 展开角色的继承关系
+func newRbacPolicy(roles []rbacRole) rbacPolicy {
+	index := map[string]rbacRole{}
+	for _, role := range roles {
+		index[role.Name] = role
+	}
+
+	policy := rbacPolicy{}
+	for _, role := range roles {
+		visited := map[string]bool{}
+		var expand func(name string)
+		expand = func(name string) {
+			if visited[name] {
+				return
+			}
+			visited[name] = true
+			item, ok := index[name]
+			if !ok {
+				return
+			}
+			policy[role.Name] = append(policy[role.Name], item.Permissions...)
+			for _, parent := range item.Inherits {
+				expand(parent)
+			}
+		}
+		expand(role.Name)
+	}
+	return policy
+}
+
+func matchPolicy(rule, value string) bool {
+	if rule == "*" || strings.EqualFold(rule, value) {
+		return true
+	}
+	if strings.HasSuffix(rule, "*") {
+		return strings.HasPrefix(value, strings.TrimSuffix(rule, "*"))
+	}
+	return false
+}
+
+// allow 判断权限是否满足条件
+func (p rbacPermission) allow(ctx *Context, action, resource string) bool {
+	if !matchPolicy(p.Action, action) || !matchPolicy(p.Resource, resource) {
+		return false
+	}
+	if len(p.Conditions) != 0 {
+		claims := ctx.Claims()
+		for key, val := range p.Conditions {
+			// ${xxx} 表示与声明中的另一个属性比较
+			if strings.HasPrefix(val, "${") && strings.HasSuffix(val, "}") {
+				val = claims.GetString(val[2 : len(val)-1])
+			}
+			if claims.GetString(key) != val || val == "" {
+				return false
+			}
+		}
+	}
+	if p.Condition != "" {
+		conditionMutex.RLock()
+		fn, ok := policyConditions[p.Condition]
+		conditionMutex.RUnlock()
+		if !ok || !fn(ctx, action, resource) {
+			return false
+		}
+	}
+	return true
+}
+
+// Roles 获取当前用户的角色
+func (c *Context) Roles() []string {
+	if roles, ok := c.Context.Value(RolesKey).([]string); ok {
+		return roles
+	}
+	return c.Claims().GetStringSlice(loadRbacConfig().RoleClaim)
+}
+
+// Can 判断当前用户是否拥有对资源执行操作的权限
+func (c *Context) Can(action, resource string) bool {
+	policy := loadRbacPolicy()
+	for _, role := range c.Roles() {
+		for _, item := range policy[role] {
+			if item.allow(c, action, resource) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RBAC 路由鉴权，使用请求方法及注册的路由作为操作和资源
+func RBAC() HandlerFunc {
+	return func(ctx *Context) {
+		if !loadRbacConfig().Enable || ctx.Pattern == "" {
+			return
+		}
+		if !ctx.Can(ctx.Method, ctx.Pattern) {
+			ctx.Fail(http.StatusForbidden, "permission denied")
+		}
+	}
+}
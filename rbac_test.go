@@ -0,0 +1,118 @@
+package core
+
+import (
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRBAC(t *testing.T) {
+	RegisterPolicyCondition("weekday", func(ctx *Context, action, resource string) bool {
+		return ctx.Request.Header.Get("X-Day") != "sunday"
+	})
+	roles := []rbacRole{
+		{Name: "viewer", Permissions: []rbacPermission{{Action: "GET", Resource: "/order/*"}}},
+		{Name: "editor", Inherits: []string{"viewer"}, Permissions: []rbacPermission{
+			{Action: "PUT", Resource: "/order/:id", Conditions: map[string]string{"tenant": "${org}"}},
+		}},
+		{Name: "admin", Inherits: []string{"editor", "admin"}, Permissions: []rbacPermission{
+			{Action: "*", Resource: "/admin/*", Condition: "weekday"},
+		}},
+		{Name: "auditor", Permissions: []rbacPermission{
+			{Action: "GET", Resource: "/report", Conditions: map[string]string{"level": "senior"}},
+		}},
+	}
+	rbacConf.Store(rbacConfig{Enable: true, Source: "config", RoleClaim: "roles"})
+	rbacPolicyValue.Store(newRbacPolicy(roles))
+	defer func() {
+		rbacConf.Store(rbacConfig{})
+		rbacPolicyValue.Store(rbacPolicy(nil))
+	}()
+
+	tests := []struct {
+		name    string
+		claims  JwtClaims
+		method  string
+		pattern string
+		day     string
+		status  int
+	}{
+		{"viewer read", JwtClaims{"roles": []interface{}{"viewer"}}, "GET", "/order/:id", "", http.StatusOK},
+		{"viewer write", JwtClaims{"roles": []interface{}{"viewer"}}, "PUT", "/order/:id", "", http.StatusForbidden},
+		{"editor inherits viewer", JwtClaims{"roles": []interface{}{"editor"}}, "GET", "/order/list", "", http.StatusOK},
+		{"editor same tenant", JwtClaims{"roles": []interface{}{"editor"}, "tenant": "a", "org": "a"}, "PUT", "/order/:id", "", http.StatusOK},
+		{"editor other tenant", JwtClaims{"roles": []interface{}{"editor"}, "tenant": "a", "org": "b"}, "PUT", "/order/:id", "", http.StatusForbidden},
+		{"editor empty tenant", JwtClaims{"roles": []interface{}{"editor"}}, "PUT", "/order/:id", "", http.StatusForbidden},
+		{"admin inherits editor", JwtClaims{"roles": []interface{}{"admin"}, "tenant": "a", "org": "a"}, "PUT", "/order/:id", "", http.StatusOK},
+		{"admin custom condition", JwtClaims{"roles": []interface{}{"admin"}}, "DELETE", "/admin/user", "monday", http.StatusOK},
+		{"admin custom condition fail", JwtClaims{"roles": []interface{}{"admin"}}, "DELETE", "/admin/user", "sunday", http.StatusForbidden},
+		{"attribute condition", JwtClaims{"roles": []interface{}{"auditor"}, "level": "senior"}, "GET", "/report", "", http.StatusOK},
+		{"attribute condition fail", JwtClaims{"roles": []interface{}{"auditor"}, "level": "junior"}, "GET", "/report", "", http.StatusForbidden},
+		{"unknown role", JwtClaims{"roles": []interface{}{"guest"}}, "GET", "/order/:id", "", http.StatusForbidden},
+		{"no claims", nil, "GET", "/order/:id", "", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(tt.method, "/", nil)
+		r.Header.Set("X-Day", tt.day)
+		ctx := newContext(w, r)
+		ctx.Pattern = tt.pattern
+		if tt.claims != nil {
+			ctx.SetValue(JwtClaimsKey, tt.claims)
+		}
+		ctx.handlers = []HandlerFunc{RBAC(), func(ctx *Context) { ctx.String(http.StatusOK, "ok") }}
+		ctx.Next()
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+	}
+}
+
+func TestRolesFromContext(t *testing.T) {
+	rbacConf.Store(rbacConfig{RoleClaim: "roles"})
+	rbacPolicyValue.Store(newRbacPolicy([]rbacRole{{Name: "viewer", Permissions: []rbacPermission{{Action: "GET", Resource: "*"}}}}))
+	defer func() {
+		rbacConf.Store(rbacConfig{})
+		rbacPolicyValue.Store(rbacPolicy(nil))
+	}()
+
+	ctx := newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	ctx.SetValue(JwtClaimsKey, JwtClaims{"roles": []interface{}{"admin"}})
+	// 上下文中设置的角色优先于jwt声明
+	ctx.SetValue(RolesKey, []string{"viewer"})
+	if !ctx.Can("GET", "/anything") || ctx.Can("POST", "/anything") {
+		t.Fatal("roles in context should take precedence over claims")
+	}
+}
+
+func TestRbacPolicyReload(t *testing.T) {
+	defer func() {
+		rbacConf.Store(rbacConfig{})
+		rbacPolicyValue.Store(rbacPolicy(nil))
+	}()
+	v := viper.New()
+	v.Set("rbac", H{"roles": []H{{"name": "viewer", "permissions": []H{{"action": "GET", "resource": "*"}}}}})
+	initRbacConfig(v)
+	if len(loadRbacPolicy()["viewer"]) != 1 {
+		t.Fatal("policy should be loaded from config")
+	}
+
+	// 切换到数据库时在加载完成前沿用原有的策略，请求中不访问数据库
+	core, logs := observer.New(zap.DebugLevel)
+	log := globalLog
+	globalLog = zap.New(core)
+	defer func() { globalLog = log }()
+	v.Set("rbac", H{"source": "mysql", "db": "missing"})
+	initRbacConfig(v)
+	ctx := newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	ctx.SetValue(RolesKey, []string{"viewer"})
+	if !ctx.Can("GET", "/order") {
+		t.Fatal("previous policy should be kept until mysql policy is loaded")
+	}
+	if logs.Len() != 0 {
+		t.Fatalf("request should not load policy from mysql: %v", logs.All())
+	}
+}
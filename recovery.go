@@ -0,0 +1,312 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"net"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+type recoveryConfig struct {
+	Status      int           `json:"status" mapstructure:"status"`             //默认处理函数响应的状态码
+	Message     string        `json:"message" mapstructure:"message"`           //默认处理函数响应的错误信息
+	StackDepth  int           `json:"stack_depth" mapstructure:"stack_depth"`   //记录的最大堆栈层数
+	AlertWindow time.Duration `json:"alert_window" mapstructure:"alert_window"` //告警限流的时间窗口，同一位置的panic在窗口内只告警一次
+	AlertLimit  int           `json:"alert_limit" mapstructure:"alert_limit"`   //窗口内最多告警的次数
+}
+
+var recoveryConf atomic.Value
+
+func initRecoveryConfig(v *viper.Viper) {
+	conf, err := parseRecoveryConfig(v)
+	storeConfig("recovery", &recoveryConf, conf, err)
+}
+
+func parseRecoveryConfig(v *viper.Viper) (recoveryConfig, error) {
+	conf := recoveryConfig{}
+	if err := v.UnmarshalKey("recovery", &conf); err != nil {
+		return recoveryConfig{}, err
+	}
+	if conf.Status == 0 {
+		conf.Status = http.StatusInternalServerError
+	}
+	if conf.Message == "" {
+		conf.Message = "Internal Server Error"
+	}
+	if conf.StackDepth == 0 {
+		conf.StackDepth = 32
+	}
+	if conf.AlertWindow == 0 {
+		conf.AlertWindow = time.Minute
+	}
+	if conf.AlertLimit == 0 {
+		conf.AlertLimit = 10
+	}
+	return conf, nil
+}
+
+func loadRecoveryConfig() recoveryConfig {
+	conf, _ := recoveryConf.Load().(recoveryConfig)
+	if conf.StackDepth == 0 {
+		conf.StackDepth = 32
+	}
+	return conf
+}
+
+// PanicInfo panic信息及发生时的请求信息
+type PanicInfo struct {
+	Service    string            `json:"service"`
+	Error      interface{}       `json:"-"`
+	Message    string            `json:"message"`
+	Stack      []string          `json:"stack"`
+	TraceID    string            `json:"trace_id"`
+	Method     string            `json:"method"`
+	Route      string            `json:"route"`
+	Path       string            `json:"path"`
+	Params     map[string]string `json:"params"`
+	Query      string            `json:"query"`
+	ClientIP   string            `json:"client_ip"`
+	UserID     string            `json:"user_id"`
+	BrokenPipe bool              `json:"broken_pipe"` //客户端断开连接导致的写入失败
+	Time       time.Time         `json:"time"`
+	Suppressed int               `json:"suppressed"` //上次告警后因限流未告警的次数
+}
+
+// RecoveryHandler 自定义panic后的响应，客户端断开时不会调用
+type RecoveryHandler func(ctx *Context, info *PanicInfo)
+
+// PanicHook panic告警回调，异步执行并且经过限流
+type PanicHook func(info *PanicInfo)
+
+var (
+	recoveryHandler RecoveryHandler
+	panicHooks      []PanicHook
+	recoveryMutex   sync.RWMutex
+)
+
+// SetRecoveryHandler 设置panic后的响应处理，默认按 recovery 配置输出错误信息
+func SetRecoveryHandler(handler RecoveryHandler) {
+	recoveryMutex.Lock()
+	defer recoveryMutex.Unlock()
+	recoveryHandler = handler
+}
+
+// OnPanic 注册panic告警回调，例如发送到webhook
+func OnPanic(hook PanicHook) {
+	recoveryMutex.Lock()
+	defer recoveryMutex.Unlock()
+	panicHooks = append(panicHooks, hook)
+}
+
+// panicWithStack 在其他协程中捕获的panic，保留原始的堆栈
+type panicWithStack struct {
+	value interface{}
+	stack []string
+}
+
+func (p *panicWithStack) Error() string {
+	return fmt.Sprintf("%v", p.value)
+}
+
+// panicStack 获取panic发生位置的堆栈，跳过 runtime.gopanic 及之前的恢复函数
+func panicStack(depth int) []string {
+	pcs := make([]uintptr, depth+16)
+	n := runtime.Callers(1, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+	var all, stack []string
+	for {
+		frame, more := frames.Next()
+		line := fmt.Sprintf("%s %s:%d", frame.Function, frame.File, frame.Line)
+		all = append(all, line)
+		if frame.Function == "runtime.gopanic" {
+			stack = all[:0:0]
+		} else if stack != nil {
+			stack = append(stack, line)
+		}
+		if !more {
+			break
+		}
+	}
+	// 不是在panic中调用时返回完整的堆栈
+	if stack == nil {
+		stack = all
+	}
+	if len(stack) > depth {
+		stack = stack[:depth]
+	}
+	return stack
+}
+
+// isBrokenPipe 判断是否为客户端断开导致的写入错误
+func isBrokenPipe(err interface{}) bool {
+	e, ok := err.(error)
+	if !ok {
+		return false
+	}
+	if errors.Is(e, syscall.EPIPE) || errors.Is(e, syscall.ECONNRESET) {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(e, &opErr) {
+		var sysErr *os.SyscallError
+		if errors.As(opErr, &sysErr) {
+			msg := strings.ToLower(sysErr.Error())
+			return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset by peer")
+		}
+	}
+	return false
+}
+
+// newPanicInfo 收集panic信息及请求信息
+func newPanicInfo(c *Context, err interface{}) *PanicInfo {
+	var stack []string
+	if p, ok := err.(*panicWithStack); ok {
+		err, stack = p.value, p.stack
+	} else {
+		stack = panicStack(loadRecoveryConfig().StackDepth)
+	}
+	info := &PanicInfo{
+		Service:    globalServiceName,
+		Error:      err,
+		Message:    fmt.Sprintf("%v", err),
+		Stack:      stack,
+		BrokenPipe: isBrokenPipe(err),
+		Time:       time.Now(),
+	}
+	if c != nil {
+		info.TraceID = c.TraceID
+		info.Method = c.Method
+		info.Route = c.Pattern
+		info.Path = c.Path
+		info.Params = c.Params
+		info.UserID = c.GetString(UserIDKey)
+		if c.Request != nil {
+			info.Query = c.Request.URL.RawQuery
+			info.ClientIP = c.ClientIP()
+		}
+	}
+	return info
+}
+
+func (p *PanicInfo) fields() []zap.Field {
+	return []zap.Field{
+		zap.String("method", p.Method),
+		zap.String("route", p.Route),
+		zap.String("path", p.Path),
+		zap.Any("params", p.Params),
+		zap.String("query", p.Query),
+		zap.String("ip", p.ClientIP),
+		zap.String(UserIDKey, p.UserID),
+		zap.Any("trace", p.Stack),
+	}
+}
+
+// panicAlerter 告警限流，同一位置的panic在窗口内只告警一次，窗口内总告警数不超过上限
+type panicAlerter struct {
+	mu          sync.Mutex
+	windowStart time.Time
+	count       int
+	sent        map[string]bool
+	suppressed  int
+}
+
+var alerter = &panicAlerter{sent: map[string]bool{}}
+
+func (a *panicAlerter) allow(info *PanicInfo) bool {
+	conf := loadRecoveryConfig()
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	now := time.Now()
+	if now.Sub(a.windowStart) >= conf.AlertWindow {
+		a.windowStart = now
+		a.count = 0
+		a.sent = map[string]bool{}
+	}
+	// 以错误信息及panic位置区分
+	key := info.Message
+	if len(info.Stack) != 0 {
+		key += "|" + info.Stack[0]
+	}
+	if a.sent[key] || (conf.AlertLimit > 0 && a.count >= conf.AlertLimit) {
+		a.suppressed++
+		return false
+	}
+	a.sent[key] = true
+	a.count++
+	info.Suppressed = a.suppressed
+	a.suppressed = 0
+	return true
+}
+
+// notifyPanic 异步调用告警回调
+func notifyPanic(info *PanicInfo) {
+	recoveryMutex.RLock()
+	hooks := panicHooks
+	recoveryMutex.RUnlock()
+	if len(hooks) == 0 || info.BrokenPipe || !alerter.allow(info) {
+		return
+	}
+	go func() {
+		for _, hook := range hooks {
+			func() {
+				defer func() {
+					if err := recover(); err != nil && globalLog != nil {
+						globalLog.Error("panic hook fail", zap.Any("error", err))
+					}
+				}()
+				hook(info)
+			}()
+		}
+	}()
+}
+
+func defaultRecoveryHandler(ctx *Context, _ *PanicInfo) {
+	conf := loadRecoveryConfig()
+	if conf.Status == 0 {
+		conf.Status, conf.Message = http.StatusInternalServerError, "Internal Server Error"
+	}
+	ctx.Fail(conf.Status, conf.Message)
+}
+
+func recovery() HandlerFunc {
+	return func(c *Context) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			// 主动中断请求，交由http.Server处理
+			if err == http.ErrAbortHandler {
+				panic(err)
+			}
+			info := newPanicInfo(c, err)
+			if info.BrokenPipe {
+				c.Log.Warn("broken pipe", append(info.fields(), zap.String("error", info.Message))...)
+				c.Abort()
+				return
+			}
+			// 已经通过回调告警，使用独立的名字避免错误日志再次告警
+			c.Log.Named("panic").Error(info.Message, info.fields()...)
+			notifyPanic(info)
+
+			recoveryMutex.RLock()
+			handler := recoveryHandler
+			recoveryMutex.RUnlock()
+			if handler == nil {
+				handler = defaultRecoveryHandler
+			}
+			handler(c, info)
+			c.Abort()
+		}()
+		c.Next()
+	}
+}
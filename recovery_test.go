@@ -0,0 +1,126 @@
+package core
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsBrokenPipe(t *testing.T) {
+	tests := []struct {
+		name string
+		err  interface{}
+		want bool
+	}{
+		{"epipe", syscall.EPIPE, true},
+		{"connection reset", syscall.ECONNRESET, true},
+		{"op error", &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}, true},
+		{"message only", errors.New("write: " + syscall.EPIPE.Error()), false},
+		{"other error", errors.New("boom"), false},
+		{"not error", "broken pipe", false},
+	}
+	for _, tt := range tests {
+		if got := isBrokenPipe(tt.err); got != tt.want {
+			t.Errorf("%s: isBrokenPipe = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPanicAlerter(t *testing.T) {
+	recoveryConf.Store(recoveryConfig{StackDepth: 32, AlertWindow: 50 * time.Millisecond, AlertLimit: 2})
+	defer recoveryConf.Store(recoveryConfig{})
+	a := &panicAlerter{sent: map[string]bool{}}
+
+	tests := []struct {
+		name       string
+		message    string
+		stack      string
+		allow      bool
+		suppressed int
+	}{
+		{"first", "boom", "a.go:1", true, 0},
+		{"same location", "boom", "a.go:1", false, 0},
+		{"same message other location", "boom", "b.go:1", true, 1},
+		{"over limit", "other", "c.go:1", false, 0},
+	}
+	for _, tt := range tests {
+		info := &PanicInfo{Message: tt.message, Stack: []string{tt.stack}}
+		if got := a.allow(info); got != tt.allow {
+			t.Errorf("%s: allow = %v, want %v", tt.name, got, tt.allow)
+		}
+		if tt.allow && info.Suppressed != tt.suppressed {
+			t.Errorf("%s: suppressed = %d, want %d", tt.name, info.Suppressed, tt.suppressed)
+		}
+	}
+
+	// 窗口结束后重新告警，并带上期间被限流的次数
+	time.Sleep(60 * time.Millisecond)
+	info := &PanicInfo{Message: "boom", Stack: []string{"a.go:1"}}
+	if !a.allow(info) || info.Suppressed != 1 {
+		t.Errorf("new window: suppressed = %d, want 1", info.Suppressed)
+	}
+}
+
+func TestRecovery(t *testing.T) {
+	recoveryConf.Store(recoveryConfig{Status: http.StatusInternalServerError, Message: "Internal Server Error", StackDepth: 32, AlertWindow: time.Minute, AlertLimit: 10})
+	hooks := panicHooks
+	alerted := make(chan *PanicInfo, 10)
+	OnPanic(func(info *PanicInfo) { alerted <- info })
+	defer func() {
+		recoveryConf.Store(recoveryConfig{})
+		panicHooks = hooks
+		alerter = &panicAlerter{sent: map[string]bool{}}
+	}()
+
+	tests := []struct {
+		name   string
+		err    interface{}
+		status int
+		body   bool
+		alert  bool
+	}{
+		{"panic", "recovery test boom", http.StatusInternalServerError, true, true},
+		{"broken pipe", &net.OpError{Op: "write", Net: "tcp", Err: os.NewSyscallError("write", syscall.EPIPE)}, http.StatusOK, false, false},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		ctx := newContext(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		called := false
+		ctx.handlers = []HandlerFunc{recovery(), func(ctx *Context) { panic(tt.err) }, func(ctx *Context) { called = true }}
+		ctx.Next()
+		if w.Code != tt.status || (w.Body.Len() != 0) != tt.body {
+			t.Errorf("%s: got %d %q", tt.name, w.Code, w.Body.String())
+		}
+		if called {
+			t.Errorf("%s: handlers after panic should not run", tt.name)
+		}
+		select {
+		case info := <-alerted:
+			if !tt.alert {
+				t.Errorf("%s: should not alert", tt.name)
+			} else if info.Message != tt.err || len(info.Stack) == 0 {
+				t.Errorf("%s: unexpected panic info %+v", tt.name, info)
+			}
+		case <-time.After(100 * time.Millisecond):
+			if tt.alert {
+				t.Errorf("%s: should alert", tt.name)
+			}
+		}
+	}
+
+	func() {
+		defer func() {
+			if err := recover(); err != http.ErrAbortHandler {
+				t.Errorf("ErrAbortHandler should be re-panicked, got %v", err)
+			}
+		}()
+		ctx := newContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+		ctx.handlers = []HandlerFunc{recovery(), func(ctx *Context) { panic(http.ErrAbortHandler) }}
+		ctx.Next()
+	}()
+}
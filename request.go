@@ -43,15 +43,17 @@ func (h *httpTool) log() {
 	}
 	logs := []zap.Field{
 		zap.Any("method", h.request.Method),
-		zap.Any("url", h.request.URL),
-		zap.Any("header", h.request.Header),
-		zap.Any("body", h.request.Body),
+		zap.Any("url", MaskString(h.request.URL)),
+		zap.Any("header", MaskHeader(h.request.Header)),
+	}
+	if conf := loadBodyLogConfig(); conf.Outbound {
+		logs = append(logs, zap.Any("body", outboundBody(conf, h.request.Header, h.request.Body)))
 	}
 	if len(h.request.FormData) != 0 {
-		logs = append(logs, zap.Any("form-data", h.request.FormData))
+		logs = append(logs, zap.Any("form-data", maskValues(h.request.FormData)))
 	}
 	if len(h.request.QueryParam) != 0 {
-		logs = append(logs, zap.Any("query-data", h.request.QueryParam))
+		logs = append(logs, zap.Any("query-data", maskValues(h.request.QueryParam)))
 	}
 	h.ctx.Log.Info(globalRequestConfig.RequestMsg, logs...)
 }
@@ -112,9 +114,11 @@ func (h *httpResult) log() {
 	logs := []zap.Field{
 		zap.Any("status", h.response.Status()),
 		zap.Any("time", h.response.Time()),
-		zap.Any("body", string(h.response.Body())),
 		zap.Any("error", h.err),
 	}
+	if conf := loadBodyLogConfig(); conf.Outbound && conf.loggable(h.response.Header()) {
+		logs = append(logs, zap.Any("body", conf.format(h.response.Body())))
+	}
 	h.ctx.Log.Info(globalRequestConfig.ResponseMsg, logs...)
 }
 
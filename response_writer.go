@@ -0,0 +1,144 @@
+package core
+
+import (
+	"bytes"
+	"net/http"
+	"sync"
+)
+
+// ResponseWriter 在http.ResponseWriter的基础上记录响应状态及大小
+type ResponseWriter interface {
+	http.ResponseWriter
+	Status() int   // 响应状态码
+	Size() int     // 已写入的响应体大小
+	Written() bool // 是否已经写入了响应头
+}
+
+type responseWriter struct {
+	http.ResponseWriter
+	status  int
+	size    int
+	written bool
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w, status: http.StatusOK}
+}
+
+func (w *responseWriter) WriteHeader(code int) {
+	if w.written {
+		return
+	}
+	w.status = code
+	w.written = true
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *responseWriter) Write(b []byte) (int, error) {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.size += n
+	return n, err
+}
+
+func (w *responseWriter) Flush() {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+func (w *responseWriter) Status() int {
+	return w.status
+}
+
+func (w *responseWriter) Size() int {
+	return w.size
+}
+
+func (w *responseWriter) Written() bool {
+	return w.written
+}
+
+// bufferWriter 将响应缓存在内存中，由调用方决定何时输出到真实的响应
+type bufferWriter struct {
+	mu      sync.Mutex
+	header  http.Header
+	buf     bytes.Buffer
+	status  int
+	written bool
+	closed  bool // 关闭后不再接收写入
+}
+
+func newBufferWriter() *bufferWriter {
+	return &bufferWriter{header: http.Header{}, status: http.StatusOK}
+}
+
+func (w *bufferWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed || w.written {
+		return
+	}
+	w.status = code
+	w.written = true
+}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.written = true
+	return w.buf.Write(b)
+}
+
+func (w *bufferWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+func (w *bufferWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.buf.Len()
+}
+
+func (w *bufferWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written
+}
+
+// close 关闭缓冲区，之后的写入都会失败
+func (w *bufferWriter) close() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+}
+
+// flushTo 将缓存的响应一次性输出，并关闭缓冲区，未写入响应时只复制响应头，由外层决定最终的响应
+func (w *bufferWriter) flushTo(dst http.ResponseWriter) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	header := dst.Header()
+	for key, val := range w.header {
+		header[key] = val
+	}
+	if !w.written {
+		return
+	}
+	dst.WriteHeader(w.status)
+	dst.Write(w.buf.Bytes())
+}
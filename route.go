@@ -1,6 +1,8 @@
 package core
 
 import (
+	"net/http"
+	"sort"
 	"strings"
 )
 
@@ -8,13 +10,13 @@ type HandlerFunc func(ctx *Context)
 
 type router struct {
 	roots    map[string]*node
-	handlers map[string]HandlerFunc
+	handlers map[string][]HandlerFunc
 }
 
 func newRouter() *router {
 	return &router{
 		roots:    make(map[string]*node),
-		handlers: make(map[string]HandlerFunc),
+		handlers: make(map[string][]HandlerFunc),
 	}
 }
 
@@ -32,7 +34,36 @@ func parsePattern(pattern string) []string {
 	return parts
 }
 
-func (e *router) addRoute(method string, pattern string, f HandlerFunc) {
+// routeRule 路由匹配规则，method为空时匹配所有请求方法，path以*结尾时按前缀匹配
+type routeRule struct {
+	Method string `json:"method" mapstructure:"method"`
+	Path   string `json:"path" mapstructure:"path"`
+}
+
+// match 使用注册的路由规则进行匹配，未匹配到路由时使用请求路径
+func (r routeRule) match(ctx *Context) bool {
+	path := ctx.Pattern
+	if path == "" {
+		path = ctx.Path
+	}
+	return r.matchRequest(ctx.Method, path)
+}
+
+// matchRequest 按请求方法及路径匹配，路径以*结尾时按前缀匹配
+func (r routeRule) matchRequest(method, path string) bool {
+	if r.Method != "" && !strings.EqualFold(r.Method, method) {
+		return false
+	}
+	if r.Path == "" || r.Path == path {
+		return true
+	}
+	if strings.HasSuffix(r.Path, "*") {
+		return strings.HasPrefix(path, strings.TrimSuffix(r.Path, "*"))
+	}
+	return false
+}
+
+func (e *router) addRoute(method string, pattern string, handlers []HandlerFunc) {
 	parts := parsePattern(pattern)
 	key := method + "-" + pattern
 	_, ok := e.roots[method]
@@ -40,7 +71,7 @@ func (e *router) addRoute(method string, pattern string, f HandlerFunc) {
 		e.roots[method] = &node{}
 	}
 	e.roots[method].insert(pattern, parts, 0)
-	e.handlers[key] = f
+	e.handlers[key] = handlers
 }
 
 func (e *router) getRoute(method string, path string) (*node, map[string]string) {
@@ -68,14 +99,40 @@ func (e *router) getRoute(method string, path string) (*node, map[string]string)
 	return nil, nil
 }
 
+// allowed 获取路径支持的请求方法
+func (e *router) allowed(path string) []string {
+	var methods []string
+	for method := range e.roots {
+		if n, _ := e.getRoute(method, path); n != nil {
+			methods = append(methods, method)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// handler 匹配路由后依次执行中间件及路由的处理函数
+// 未匹配的路由同样先经过全局及分组中间件，再响应404或自动的OPTIONS，
+// 使跨域、访问日志等中间件对这类响应生效，鉴权、限流等中间件也可能先于404中断请求
 func (e *router) handler(ctx *Context) {
 	n, params := e.getRoute(ctx.Method, ctx.Path)
-	if n != nil {
+	switch {
+	case n != nil:
 		ctx.Params = params
+		ctx.Pattern = n.pattern
 		key := ctx.Method + "-" + n.pattern
-		ctx.handlers = append(ctx.handlers, e.handlers[key])
-	} else {
-		ctx.String(404, "NOT FOUND URL %v", ctx.Path)
+		ctx.handlers = append(ctx.handlers, e.handlers[key]...)
+	case ctx.Method == http.MethodOptions && len(e.allowed(ctx.Path)) != 0:
+		// 未注册OPTIONS的路由，自动响应支持的请求方法
+		ctx.handlers = append(ctx.handlers, func(ctx *Context) {
+			methods := append(e.allowed(ctx.Path), http.MethodOptions)
+			ctx.SetHeader("Allow", strings.Join(methods, ", "))
+			ctx.Status(http.StatusNoContent)
+		})
+	default:
+		ctx.handlers = append(ctx.handlers, func(ctx *Context) {
+			ctx.String(http.StatusNotFound, "NOT FOUND URL %v", ctx.Path)
+		})
 	}
 	ctx.Next()
 }
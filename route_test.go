@@ -0,0 +1,43 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRouterHandler(t *testing.T) {
+	r := newRouter()
+	r.addRoute(http.MethodGet, "/user/:id", []HandlerFunc{func(ctx *Context) {
+		ctx.String(http.StatusOK, ctx.Param("id"))
+	}})
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		auth   bool
+		status int
+		allow  string
+	}{
+		{"matched", http.MethodGet, "/user/1", true, http.StatusOK, ""},
+		{"not found", http.MethodGet, "/order/1", true, http.StatusNotFound, ""},
+		{"options", http.MethodOptions, "/user/1", true, http.StatusNoContent, "GET, OPTIONS"},
+		{"not found after middleware", http.MethodGet, "/order/1", false, http.StatusUnauthorized, ""},
+	}
+	for _, tt := range tests {
+		var called bool
+		w := httptest.NewRecorder()
+		ctx := newContext(w, httptest.NewRequest(tt.method, tt.path, nil))
+		ctx.handlers = []HandlerFunc{func(ctx *Context) {
+			called = true
+			if !tt.auth {
+				ctx.Fail(http.StatusUnauthorized, "unauthorized")
+			}
+		}}
+		r.handler(ctx)
+		if !called || w.Code != tt.status || w.Header().Get("Allow") != tt.allow {
+			t.Errorf("%s: got status %d allow %q, middleware called %v", tt.name, w.Code, w.Header().Get("Allow"), called)
+		}
+	}
+}
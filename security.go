@@ -0,0 +1,152 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/spf13/viper"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// CSPNonceKey 当前请求的CSP nonce在上下文及模板数据中的key
+var CSPNonceKey = "csp_nonce"
+
+type securityConfig struct {
+	Enable                bool   `json:"enable" mapstructure:"enable"`
+	HstsMaxAge            int    `json:"hsts_max_age" mapstructure:"hsts_max_age"`                       //HSTS有效期，单位秒，0为不设置，仅https请求生效
+	HstsIncludeSubdomains bool   `json:"hsts_include_subdomains" mapstructure:"hsts_include_subdomains"` //HSTS是否包含子域名
+	HstsPreload           bool   `json:"hsts_preload" mapstructure:"hsts_preload"`
+	ContentTypeNosniff    bool   `json:"content_type_nosniff" mapstructure:"content_type_nosniff"` //是否设置 X-Content-Type-Options: nosniff
+	FrameOptions          string `json:"frame_options" mapstructure:"frame_options"`               //X-Frame-Options DENY/SAMEORIGIN
+	ReferrerPolicy        string `json:"referrer_policy" mapstructure:"referrer_policy"`
+	ContentSecurityPolicy string `json:"content_security_policy" mapstructure:"content_security_policy"` //CSP，{nonce} 会替换为每个请求独立的随机数
+	CspReportOnly         bool   `json:"csp_report_only" mapstructure:"csp_report_only"`                 //仅上报不拦截
+
+	hsts string
+}
+
+var securityConf atomic.Value
+
+func initSecurityConfig(v *viper.Viper) {
+	conf, err := parseSecurityConfig(v)
+	storeConfig("security", &securityConf, conf, err)
+}
+
+func parseSecurityConfig(v *viper.Viper) (*securityConfig, error) {
+	conf := securityConfig{}
+	if err := v.UnmarshalKey("security", &conf); err != nil {
+		return nil, err
+	}
+	if conf.HstsMaxAge > 0 {
+		conf.hsts = "max-age=" + strconv.Itoa(conf.HstsMaxAge)
+		if conf.HstsIncludeSubdomains {
+			conf.hsts += "; includeSubDomains"
+		}
+		if conf.HstsPreload {
+			conf.hsts += "; preload"
+		}
+	}
+	return &conf, nil
+}
+
+func loadSecurityConfig() *securityConfig {
+	conf, _ := securityConf.Load().(*securityConfig)
+	if conf == nil {
+		return &securityConfig{}
+	}
+	return conf
+}
+
+// randomToken 生成url安全的随机串
+func randomToken(size int) string {
+	buf := make([]byte, size)
+	if _, err := rand.Read(buf); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(buf)
+}
+
+// isHTTPS 判断请求是否为https，只信任 system.trusted_proxies 中的代理设置的 X-Forwarded-Proto
+func isHTTPS(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	remote := strings.TrimSpace(r.RemoteAddr)
+	if ip, _, err := net.SplitHostPort(remote); err == nil {
+		remote = ip
+	}
+	return isTrustedProxy(remote) && strings.EqualFold(r.Header.Get("X-Forwarded-Proto"), "https")
+}
+
+// CSPNonce 获取当前请求的CSP nonce，未启用时为空
+func (c *Context) CSPNonce() string {
+	return c.GetString(CSPNonceKey)
+}
+
+// templateData 将CSP nonce及CSRF token注入到模板数据中，仅支持 H 及 map[string]interface{}
+func (c *Context) templateData(data interface{}) interface{} {
+	values := H{}
+	if nonce := c.CSPNonce(); nonce != "" {
+		values[CSPNonceKey] = nonce
+	}
+	if token := c.CSRFToken(); token != "" {
+		values[CSRFTokenKey] = token
+	}
+	if len(values) == 0 {
+		return data
+	}
+
+	var src map[string]interface{}
+	switch val := data.(type) {
+	case nil:
+	case H:
+		src = val
+	case map[string]interface{}:
+		src = val
+	default:
+		return data
+	}
+	// 复制一份，避免修改调用方的数据
+	for key, val := range src {
+		values[key] = val
+	}
+	return values
+}
+
+// Secure 输出安全相关的响应头
+func Secure() HandlerFunc {
+	return func(ctx *Context) {
+		conf := loadSecurityConfig()
+		if !conf.Enable {
+			return
+		}
+		header := ctx.Writer.Header()
+		if conf.hsts != "" && isHTTPS(ctx.Request) {
+			header.Set("Strict-Transport-Security", conf.hsts)
+		}
+		if conf.ContentTypeNosniff {
+			header.Set("X-Content-Type-Options", "nosniff")
+		}
+		if conf.FrameOptions != "" {
+			header.Set("X-Frame-Options", conf.FrameOptions)
+		}
+		if conf.ReferrerPolicy != "" {
+			header.Set("Referrer-Policy", conf.ReferrerPolicy)
+		}
+		if policy := conf.ContentSecurityPolicy; policy != "" {
+			if strings.Contains(policy, "{nonce}") {
+				nonce := randomToken(16)
+				ctx.SetValue(CSPNonceKey, nonce)
+				policy = strings.ReplaceAll(policy, "{nonce}", nonce)
+			}
+			name := "Content-Security-Policy"
+			if conf.CspReportOnly {
+				name = "Content-Security-Policy-Report-Only"
+			}
+			header.Set(name, policy)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package core
+
+import (
+	"crypto/tls"
+	"github.com/spf13/viper"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecure(t *testing.T) {
+	v := viper.New()
+	v.Set("security", map[string]interface{}{
+		"enable":                  true,
+		"hsts_max_age":            31536000,
+		"hsts_include_subdomains": true,
+		"content_type_nosniff":    true,
+		"frame_options":           "DENY",
+		"content_security_policy": "script-src 'nonce-{nonce}'",
+	})
+	conf, err := parseSecurityConfig(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	securityConf.Store(conf)
+	defer securityConf.Store(&securityConfig{})
+	system := globalSystemConfig
+	proxy, _ := parseIPNet("192.0.2.1")
+	globalSystemConfig.trustedNets = []*net.IPNet{proxy}
+	defer func() { globalSystemConfig = system }()
+
+	serve := func(proto string) (*httptest.ResponseRecorder, string) {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set("X-Forwarded-Proto", proto)
+		ctx := newContext(w, r)
+		var nonce string
+		var data interface{}
+		ctx.handlers = []HandlerFunc{Secure(), func(ctx *Context) {
+			nonce = ctx.CSPNonce()
+			data = ctx.templateData(H{"title": "home"})
+		}}
+		ctx.Next()
+		if values, ok := data.(H); !ok || values[CSPNonceKey] != nonce || values["title"] != "home" {
+			t.Errorf("template data should contain nonce, got %v", data)
+		}
+		return w, nonce
+	}
+
+	w1, nonce1 := serve("https")
+	w2, nonce2 := serve("http")
+	if nonce1 == "" || nonce1 == nonce2 {
+		t.Fatalf("nonce should be random per request, got %q %q", nonce1, nonce2)
+	}
+	if got := w1.Header().Get("Content-Security-Policy"); got != "script-src 'nonce-"+nonce1+"'" {
+		t.Errorf("Content-Security-Policy = %q", got)
+	}
+	if got := w1.Header().Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains" {
+		t.Errorf("Strict-Transport-Security = %q", got)
+	}
+	if w2.Header().Get("Strict-Transport-Security") != "" {
+		t.Error("HSTS should only be sent over https")
+	}
+	if w1.Header().Get("X-Content-Type-Options") != "nosniff" || w1.Header().Get("X-Frame-Options") != "DENY" {
+		t.Errorf("missing security headers: %v", w1.Header())
+	}
+
+	conf.CspReportOnly = true
+	w3, _ := serve("http")
+	if w3.Header().Get("Content-Security-Policy") != "" || !strings.HasPrefix(w3.Header().Get("Content-Security-Policy-Report-Only"), "script-src") {
+		t.Errorf("report only header mismatch: %v", w3.Header())
+	}
+}
+
+func TestIsHTTPS(t *testing.T) {
+	system := globalSystemConfig
+	proxy, _ := parseIPNet("10.0.0.0/8")
+	globalSystemConfig.trustedNets = []*net.IPNet{proxy}
+	defer func() { globalSystemConfig = system }()
+
+	tests := []struct {
+		name   string
+		remote string
+		proto  string
+		tls    bool
+		want   bool
+	}{
+		{"tls", "1.1.1.1:80", "", true, true},
+		{"trusted proxy", "10.0.0.1:80", "https", false, true},
+		{"trusted proxy http", "10.0.0.1:80", "http", false, false},
+		{"spoofed by client", "1.1.1.1:80", "https", false, false},
+		{"plain http", "1.1.1.1:80", "", false, false},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = tt.remote
+		r.Header.Set("X-Forwarded-Proto", tt.proto)
+		if tt.tls {
+			r.TLS = &tls.ConnectionState{}
+		}
+		if got := isHTTPS(r); got != tt.want {
+			t.Errorf("%s: isHTTPS = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
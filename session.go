@@ -0,0 +1,521 @@
+package core
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/go-redis/redis/v8"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SessionKey 当前请求的会话在上下文中的key
+var SessionKey = "session"
+
+// 保存闪存消息的会话字段
+const sessionFlashKey = "_flashes"
+
+var (
+	ErrSessionTooLarge = errors.New("session is too large for cookie")
+	errSessionInvalid  = errors.New("session cookie is invalid")
+)
+
+type sessionConfig struct {
+	Enable         bool          `json:"enable" mapstructure:"enable"`
+	Store          string        `json:"store" mapstructure:"store"`                       //cookie 加密后保存在cookie中，无法在服务端吊销，memory 本机内存，redis，或通过 RegisterSessionStore 注册的存储
+	Secret         string        `json:"secret" mapstructure:"secret"`                     //cookie 存储的加密密钥
+	Prefix         string        `json:"prefix" mapstructure:"prefix"`                     //redis 存储的key前缀
+	MaxAge         time.Duration `json:"max_age" mapstructure:"max_age"`                   //会话有效期
+	Rolling        bool          `json:"rolling" mapstructure:"rolling"`                   //每次请求都重新计算有效期
+	CookieName     string        `json:"cookie_name" mapstructure:"cookie_name"`           //cookie名称
+	CookiePath     string        `json:"cookie_path" mapstructure:"cookie_path"`           //cookie路径
+	CookieDomain   string        `json:"cookie_domain" mapstructure:"cookie_domain"`       //cookie域名
+	CookieSecure   bool          `json:"cookie_secure" mapstructure:"cookie_secure"`       //仅https发送cookie
+	CookieSameSite string        `json:"cookie_same_site" mapstructure:"cookie_same_site"` //lax/strict/none
+
+	aead cipher.AEAD
+}
+
+var sessionConf atomic.Value
+
+func initSessionConfig(v *viper.Viper) {
+	conf, err := parseSessionConfig(v)
+	storeConfig("session", &sessionConf, conf, err)
+}
+
+func parseSessionConfig(v *viper.Viper) (*sessionConfig, error) {
+	conf := sessionConfig{}
+	if err := v.UnmarshalKey("session", &conf); err != nil {
+		return nil, err
+	}
+	if conf.Store == "" {
+		conf.Store = "cookie"
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = "session"
+	}
+	if conf.MaxAge == 0 {
+		conf.MaxAge = 24 * time.Hour
+	}
+	if conf.CookieName == "" {
+		conf.CookieName = "session_id"
+	}
+	if conf.CookiePath == "" {
+		conf.CookiePath = "/"
+	}
+	if conf.Enable && conf.Store == "cookie" {
+		if conf.Secret == "" {
+			return nil, errors.New("cookie store requires secret")
+		}
+		// 密钥做一次摘要，任意长度的密钥都可以作为AES-256的密钥
+		key := sha256.Sum256([]byte(conf.Secret))
+		block, err := aes.NewCipher(key[:])
+		if err != nil {
+			return nil, err
+		}
+		if conf.aead, err = cipher.NewGCM(block); err != nil {
+			return nil, err
+		}
+	}
+	return &conf, nil
+}
+
+func loadSessionConfig() *sessionConfig {
+	conf, _ := sessionConf.Load().(*sessionConfig)
+	if conf == nil {
+		return &sessionConfig{}
+	}
+	return conf
+}
+
+// SessionStore 会话存储
+type SessionStore interface {
+	// Load 根据cookie的值加载会话，返回会话ID及数据，会话不存在时返回空数据
+	Load(ctx context.Context, cookie string) (string, []byte, error)
+	// Save 保存会话数据，返回写入cookie的值
+	Save(ctx context.Context, id string, data []byte, ttl time.Duration) (string, error)
+	// Delete 删除会话
+	Delete(ctx context.Context, id string) error
+}
+
+var (
+	sessionStores     = map[string]SessionStore{"memory": newMemorySessionStore()}
+	sessionStoreMutex sync.RWMutex
+)
+
+// RegisterSessionStore 注册自定义的会话存储，在 session.store 中引用
+func RegisterSessionStore(name string, store SessionStore) {
+	sessionStoreMutex.Lock()
+	defer sessionStoreMutex.Unlock()
+	sessionStores[name] = store
+}
+
+func getSessionStore(conf *sessionConfig) SessionStore {
+	switch conf.Store {
+	case "cookie":
+		return &cookieSessionStore{aead: conf.aead}
+	case "redis":
+		if globalRedisConnect != nil {
+			return &redisSessionStore{client: globalRedisConnect, prefix: conf.Prefix}
+		}
+	}
+	sessionStoreMutex.RLock()
+	defer sessionStoreMutex.RUnlock()
+	if store, ok := sessionStores[conf.Store]; ok {
+		return store
+	}
+	// 未启用redis时使用本机内存
+	return sessionStores["memory"]
+}
+
+// cookieSessionStore 会话数据加密后直接保存在cookie中，格式为 base64(nonce + 密文)
+// 服务端不保存会话，Delete 无法使已经发出的cookie失效，复制的cookie在有效期内仍然可用
+type cookieSessionStore struct {
+	aead cipher.AEAD
+}
+
+func (s *cookieSessionStore) Load(_ context.Context, cookie string) (string, []byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cookie)
+	if err != nil || len(raw) < s.aead.NonceSize() {
+		return "", nil, errSessionInvalid
+	}
+	nonce, ciphertext := raw[:s.aead.NonceSize()], raw[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", nil, errSessionInvalid
+	}
+	index := strings.IndexByte(string(plain), '\n')
+	if index < 0 {
+		return "", nil, errSessionInvalid
+	}
+	return string(plain[:index]), plain[index+1:], nil
+}
+
+func (s *cookieSessionStore) Save(_ context.Context, id string, data []byte, _ time.Duration) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	plain := append([]byte(id+"\n"), data...)
+	cookie := base64.RawURLEncoding.EncodeToString(s.aead.Seal(nonce, nonce, plain, nil))
+	// 浏览器限制单个cookie不超过4KB
+	if len(cookie) > 4000 {
+		return "", ErrSessionTooLarge
+	}
+	return cookie, nil
+}
+
+func (s *cookieSessionStore) Delete(context.Context, string) error {
+	return nil
+}
+
+type memorySession struct {
+	data     []byte
+	expireAt time.Time
+}
+
+// memorySessionStore 本机内存存储，仅适用于单实例部署
+type memorySessionStore struct {
+	mu        sync.Mutex
+	items     map[string]memorySession
+	lastSweep time.Time
+}
+
+func newMemorySessionStore() *memorySessionStore {
+	return &memorySessionStore{items: map[string]memorySession{}, lastSweep: time.Now()}
+}
+
+func (s *memorySessionStore) Load(_ context.Context, cookie string) (string, []byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	item, ok := s.items[cookie]
+	if !ok {
+		return cookie, nil, nil
+	}
+	if time.Now().After(item.expireAt) {
+		delete(s.items, cookie)
+		return cookie, nil, nil
+	}
+	return cookie, item.data, nil
+}
+
+func (s *memorySessionStore) Save(_ context.Context, id string, data []byte, ttl time.Duration) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	s.items[id] = memorySession{data: data, expireAt: now.Add(ttl)}
+	// 定期清理过期的会话
+	if now.Sub(s.lastSweep) >= time.Minute {
+		s.lastSweep = now
+		for key, item := range s.items {
+			if now.After(item.expireAt) {
+				delete(s.items, key)
+			}
+		}
+	}
+	return id, nil
+}
+
+func (s *memorySessionStore) Delete(_ context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.items, id)
+	return nil
+}
+
+// redisSessionStore 会话保存在redis中，cookie中只保存会话ID
+type redisSessionStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func (s *redisSessionStore) Load(ctx context.Context, cookie string) (string, []byte, error) {
+	data, err := s.client.Get(ctx, s.prefix+":"+cookie).Bytes()
+	if err == redis.Nil {
+		return cookie, nil, nil
+	}
+	return cookie, data, err
+}
+
+func (s *redisSessionStore) Save(ctx context.Context, id string, data []byte, ttl time.Duration) (string, error) {
+	return id, s.client.Set(ctx, s.prefix+":"+id, data, ttl).Err()
+}
+
+func (s *redisSessionStore) Delete(ctx context.Context, id string) error {
+	return s.client.Del(ctx, s.prefix+":"+id).Err()
+}
+
+// sessionRecord 保存到存储中的会话数据
+type sessionRecord struct {
+	Values  map[string]interface{} `json:"values"`
+	Expires int64                  `json:"expires"` //过期时间，秒级时间戳
+}
+
+// Session 当前请求的会话，数据以json格式保存，读取后数字类型为float64
+type Session struct {
+	ctx     *Context
+	conf    *sessionConfig
+	store   SessionStore
+	id      string
+	oldID   string //重新生成ID前的会话ID，保存时删除
+	values  map[string]interface{}
+	expires time.Time
+	isNew   bool
+	loaded  bool //客户端持有有效的会话cookie
+	changed bool
+	touched bool //已经按 rolling 刷新过有效期
+}
+
+// loadSession 根据请求中的cookie加载会话，不存在或已过期时创建新的会话
+func loadSession(ctx *Context, conf *sessionConfig) *Session {
+	s := &Session{ctx: ctx, conf: conf, store: getSessionStore(conf), values: map[string]interface{}{}}
+	if cookie, err := ctx.Request.Cookie(conf.CookieName); err == nil && cookie.Value != "" {
+		id, data, err := s.store.Load(ctx, cookie.Value)
+		if err != nil && err != errSessionInvalid {
+			ctx.Log.Warn("session load fail", zap.Error(err))
+		}
+		record := sessionRecord{}
+		if err == nil && len(data) != 0 && json.Unmarshal(data, &record) == nil && time.Now().Unix() < record.Expires {
+			s.id = id
+			s.loaded = true
+			s.expires = time.Unix(record.Expires, 0)
+			if record.Values != nil {
+				s.values = record.Values
+			}
+			return s
+		}
+	}
+	s.id = randomToken(32)
+	s.expires = time.Now().Add(conf.MaxAge)
+	s.isNew = true
+	return s
+}
+
+// Session 获取当前请求的会话，未启用 Session 中间件时返回不会保存的空会话
+func (c *Context) Session() *Session {
+	if s, ok := c.Context.Value(SessionKey).(*Session); ok {
+		return s
+	}
+	return &Session{ctx: c, values: map[string]interface{}{}, isNew: true}
+}
+
+// ID 会话ID
+func (s *Session) ID() string {
+	return s.id
+}
+
+// IsNew 是否为本次请求新建的会话
+func (s *Session) IsNew() bool {
+	return s.isNew
+}
+
+func (s *Session) Get(key string) interface{} {
+	return s.values[key]
+}
+
+func (s *Session) GetString(key string) string {
+	val, _ := s.values[key].(string)
+	return val
+}
+
+func (s *Session) GetBool(key string) bool {
+	val, _ := s.values[key].(bool)
+	return val
+}
+
+// GetInt 获取整数，兼容反序列化后的float64
+func (s *Session) GetInt(key string) int {
+	switch val := s.values[key].(type) {
+	case int:
+		return val
+	case int64:
+		return int(val)
+	case float64:
+		return int(val)
+	}
+	return 0
+}
+
+func (s *Session) Set(key string, val interface{}) {
+	s.values[key] = val
+	s.changed = true
+}
+
+func (s *Session) Delete(key string) {
+	if _, ok := s.values[key]; ok {
+		delete(s.values, key)
+		s.changed = true
+	}
+}
+
+// Clear 清空会话数据
+func (s *Session) Clear() {
+	if len(s.values) != 0 {
+		s.values = map[string]interface{}{}
+		s.changed = true
+	}
+}
+
+// AddFlash 添加闪存消息，在下一次调用 Flashes 时读取并删除
+func (s *Session) AddFlash(val interface{}) {
+	flashes, _ := s.values[sessionFlashKey].([]interface{})
+	s.values[sessionFlashKey] = append(flashes, val)
+	s.changed = true
+}
+
+// Flashes 读取并删除所有闪存消息
+func (s *Session) Flashes() []interface{} {
+	flashes, ok := s.values[sessionFlashKey].([]interface{})
+	if !ok {
+		return nil
+	}
+	delete(s.values, sessionFlashKey)
+	s.changed = true
+	return flashes
+}
+
+// Regenerate 重新生成会话ID并保留数据，登录等权限变化时调用，防止会话固定攻击
+func (s *Session) Regenerate() {
+	if s.loaded && s.oldID == "" {
+		s.oldID = s.id
+	}
+	s.id = randomToken(32)
+	if s.conf != nil {
+		s.expires = time.Now().Add(s.conf.MaxAge)
+	}
+	s.changed = true
+}
+
+// Destroy 删除会话，用于退出登录，之后写入的数据保存到新的会话中
+// cookie 存储只能清除客户端的cookie，被复制的cookie在 max_age 内仍然有效，需要服务端吊销时使用 redis 存储
+func (s *Session) Destroy() {
+	s.values = map[string]interface{}{}
+	s.Regenerate()
+}
+
+// Save 保存会话并写入cookie，需要在输出响应前调用，未调用时在输出响应前自动保存
+func (s *Session) Save() error {
+	if s.store == nil {
+		return nil
+	}
+	conf := s.conf
+	// 删除重新生成ID前的会话
+	if s.oldID != "" {
+		if err := s.store.Delete(s.ctx, s.oldID); err != nil {
+			return err
+		}
+		s.oldID = ""
+	}
+	if !s.changed && (!conf.Rolling || s.touched) {
+		return nil
+	}
+	s.changed, s.touched = false, true
+	if len(s.values) == 0 {
+		// 会话已清空，删除会话及cookie，新会话没有数据时不需要保存
+		if s.loaded {
+			s.loaded = false
+			s.setCookie("", -1)
+			return s.store.Delete(s.ctx, s.id)
+		}
+		return nil
+	}
+	if conf.Rolling {
+		s.expires = time.Now().Add(conf.MaxAge)
+	}
+	ttl := time.Until(s.expires)
+	data, err := json.Marshal(sessionRecord{Values: s.values, Expires: s.expires.Unix()})
+	if err != nil {
+		return err
+	}
+	cookie, err := s.store.Save(s.ctx, s.id, data, ttl)
+	if err != nil {
+		return err
+	}
+	s.loaded = true
+	s.setCookie(cookie, int(ttl/time.Second))
+	return nil
+}
+
+func (s *Session) setCookie(value string, maxAge int) {
+	http.SetCookie(s.ctx.Writer, &http.Cookie{
+		Name:     s.conf.CookieName,
+		Value:    value,
+		Path:     s.conf.CookiePath,
+		Domain:   s.conf.CookieDomain,
+		MaxAge:   maxAge,
+		Secure:   s.conf.CookieSecure,
+		HttpOnly: true,
+		SameSite: parseSameSite(s.conf.CookieSameSite),
+	})
+}
+
+// commit 自动保存会话，保存失败时只记录日志
+func (s *Session) commit() {
+	if err := s.Save(); err != nil {
+		s.ctx.Log.Error("session save fail", zap.Error(err))
+	}
+}
+
+// sessionWriter 在写入响应头之前保存会话，保证cookie能够写入响应
+type sessionWriter struct {
+	ResponseWriter
+	session *Session
+}
+
+func (w *sessionWriter) WriteHeader(code int) {
+	if !w.ResponseWriter.Written() {
+		w.session.commit()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *sessionWriter) Write(b []byte) (int, error) {
+	if !w.ResponseWriter.Written() {
+		w.session.commit()
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *sessionWriter) Flush() {
+	if !w.ResponseWriter.Written() {
+		w.session.commit()
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Sessions 会话中间件，按配置的存储加载会话，通过 ctx.Session() 读写
+func Sessions() HandlerFunc {
+	return func(ctx *Context) {
+		conf := loadSessionConfig()
+		if !conf.Enable {
+			return
+		}
+		s := loadSession(ctx, conf)
+		ctx.SetValue(SessionKey, s)
+
+		w := ctx.Writer
+		ctx.Writer = &sessionWriter{ResponseWriter: w, session: s}
+		defer func() {
+			ctx.Writer = w
+		}()
+		ctx.Next()
+		// 响应头已经发送时新的cookie无法写入，cookie存储的修改会丢失，服务端存储只能保存数据
+		if w.Written() && s.changed {
+			ctx.Log.Warn("session changed after response headers were sent", zap.String("store", conf.Store))
+		}
+		// 没有输出响应或响应后修改了会话，服务端存储仍然可以保存
+		s.commit()
+	}
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func serveSession(handler HandlerFunc, cookies []*http.Cookie) []*http.Cookie {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	for _, cookie := range cookies {
+		r.AddCookie(cookie)
+	}
+	w := httptest.NewRecorder()
+	ctx := newContext(w, r)
+	ctx.handlers = []HandlerFunc{Sessions(), handler}
+	ctx.Next()
+	return w.Result().Cookies()
+}
+
+func TestSession(t *testing.T) {
+	for _, store := range []string{"cookie", "memory"} {
+		v := viper.New()
+		v.Set("session", map[string]interface{}{"enable": true, "store": store, "secret": "secret"})
+		initSessionConfig(v)
+
+		login := serveSession(func(c *Context) {
+			c.Session().Set("uid", 7)
+			c.Session().AddFlash("welcome")
+			c.String(http.StatusOK, "ok")
+		}, nil)
+		if len(login) != 1 {
+			t.Fatalf("%s: session cookie not set", store)
+		}
+
+		var flashes []interface{}
+		regenerated := serveSession(func(c *Context) {
+			if c.Session().IsNew() || c.Session().GetInt("uid") != 7 {
+				t.Fatalf("%s: session not loaded", store)
+			}
+			flashes = c.Session().Flashes()
+			c.Session().Regenerate()
+		}, login)
+		if len(flashes) != 1 || flashes[0] != "welcome" {
+			t.Fatalf("%s: unexpected flashes %v", store, flashes)
+		}
+		if len(regenerated) != 1 || regenerated[0].Value == login[0].Value {
+			t.Fatalf("%s: session id not regenerated", store)
+		}
+		if store == "memory" {
+			serveSession(func(c *Context) {
+				if !c.Session().IsNew() {
+					t.Fatal("session before regenerate should be deleted")
+				}
+			}, login)
+		}
+
+		logout := serveSession(func(c *Context) {
+			if c.Session().GetInt("uid") != 7 || len(c.Session().Flashes()) != 0 {
+				t.Fatalf("%s: regenerated session lost data", store)
+			}
+			c.Session().Destroy()
+			c.String(http.StatusOK, "bye")
+		}, regenerated)
+		if len(logout) != 1 || logout[0].MaxAge != -1 {
+			t.Fatalf("%s: session cookie not cleared", store)
+		}
+
+		if cookies := serveSession(func(c *Context) { c.String(http.StatusOK, "ok") }, nil); len(cookies) != 0 {
+			t.Fatalf("%s: empty session should not set cookie", store)
+		}
+	}
+}
+
+func TestSessionChangedAfterResponse(t *testing.T) {
+	v := viper.New()
+	v.Set("session", map[string]interface{}{"enable": true, "store": "cookie", "secret": "secret"})
+	initSessionConfig(v)
+	defer sessionConf.Store(&sessionConfig{})
+
+	tests := []struct {
+		name    string
+		handler HandlerFunc
+		warn    bool
+	}{
+		{"before response", func(c *Context) {
+			c.Session().Set("uid", 1)
+			c.String(http.StatusOK, "ok")
+		}, false},
+		{"without response", func(c *Context) { c.Session().Set("uid", 1) }, false},
+		{"after response", func(c *Context) {
+			c.String(http.StatusOK, "ok")
+			c.Session().Set("uid", 1)
+		}, true},
+	}
+	for _, tt := range tests {
+		core, logs := observer.New(zap.WarnLevel)
+		w := httptest.NewRecorder()
+		ctx := newContext(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		ctx.Log = zap.New(core)
+		ctx.handlers = []HandlerFunc{Sessions(), tt.handler}
+		ctx.Next()
+		warned := logs.FilterMessage("session changed after response headers were sent").Len() == 1
+		if warned != tt.warn {
+			t.Errorf("%s: warned = %v, want %v", tt.name, warned, tt.warn)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// SignAppKey 签名校验通过后appKey在上下文中的key
+var SignAppKey = "app_key"
+
+type signatureApp struct {
+	AppKey string `json:"app_key" mapstructure:"app_key"`
+	Secret string `json:"secret" mapstructure:"secret"`
+}
+
+type signatureConfig struct {
+	Enable          bool           `json:"enable" mapstructure:"enable"`
+	Expire          time.Duration  `json:"expire" mapstructure:"expire"`                     //请求时间戳允许的误差
+	Prefix          string         `json:"prefix" mapstructure:"prefix"`                     //nonce在redis中的key前缀
+	AppKeyHeader    string         `json:"app_key_header" mapstructure:"app_key_header"`     //appKey所在的请求头
+	TimestampHeader string         `json:"timestamp_header" mapstructure:"timestamp_header"` //时间戳所在的请求头，单位秒
+	NonceHeader     string         `json:"nonce_header" mapstructure:"nonce_header"`         //随机串所在的请求头
+	SignatureHeader string         `json:"signature_header" mapstructure:"signature_header"` //签名所在的请求头
+	Apps            []signatureApp `json:"apps" mapstructure:"apps"`                         //接入方的appKey及密钥
+
+	secrets map[string]string
+}
+
+var signatureConf atomic.Value
+
+func initSignatureConfig(v *viper.Viper) {
+	conf, err := parseSignatureConfig(v)
+	storeConfig("signature", &signatureConf, conf, err)
+}
+
+func parseSignatureConfig(v *viper.Viper) (*signatureConfig, error) {
+	conf := signatureConfig{}
+	if err := v.UnmarshalKey("signature", &conf); err != nil {
+		return nil, err
+	}
+	if conf.Expire == 0 {
+		conf.Expire = 5 * time.Minute
+	}
+	if conf.Prefix == "" {
+		conf.Prefix = "signature_nonce"
+	}
+	if conf.AppKeyHeader == "" {
+		conf.AppKeyHeader = "X-App-Key"
+	}
+	if conf.TimestampHeader == "" {
+		conf.TimestampHeader = "X-Timestamp"
+	}
+	if conf.NonceHeader == "" {
+		conf.NonceHeader = "X-Nonce"
+	}
+	if conf.SignatureHeader == "" {
+		conf.SignatureHeader = "X-Signature"
+	}
+	conf.secrets = map[string]string{}
+	for _, item := range conf.Apps {
+		conf.secrets[item.AppKey] = item.Secret
+	}
+	return &conf, nil
+}
+
+func loadSignatureConfig() *signatureConfig {
+	conf, _ := signatureConf.Load().(*signatureConfig)
+	return conf
+}
+
+// canonicalQuery 按参数名及参数值排序后拼接查询参数
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var list []string
+	for _, key := range keys {
+		values := append([]string(nil), query[key]...)
+		sort.Strings(values)
+		for _, val := range values {
+			list = append(list, url.QueryEscape(key)+"="+url.QueryEscape(val))
+		}
+	}
+	return strings.Join(list, "&")
+}
+
+// Sign 生成请求签名，签名内容为
+// appKey\nMETHOD\npath\n排序后的查询参数\nhex(sha256(body))\ntimestamp\nnonce
+// 使用HMAC-SHA256计算后以hex编码
+func Sign(secret, appKey, method, path string, query url.Values, body []byte, timestamp, nonce string) string {
+	bodyHash := sha256.Sum256(body)
+	content := strings.Join([]string{
+		appKey,
+		strings.ToUpper(method),
+		path,
+		canonicalQuery(query),
+		hex.EncodeToString(bodyHash[:]),
+		timestamp,
+		nonce,
+	}, "\n")
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(content))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Signature 请求签名校验，校验通过后appKey写入上下文
+func Signature() HandlerFunc {
+	return func(ctx *Context) {
+		conf := loadSignatureConfig()
+		if conf == nil || !conf.Enable {
+			return
+		}
+
+		header := ctx.Request.Header
+		appKey := header.Get(conf.AppKeyHeader)
+		timestamp := header.Get(conf.TimestampHeader)
+		nonce := header.Get(conf.NonceHeader)
+		signature := header.Get(conf.SignatureHeader)
+		if appKey == "" || timestamp == "" || nonce == "" || signature == "" {
+			ctx.Fail(http.StatusUnauthorized, "signature params is missing")
+			return
+		}
+
+		secret, ok := conf.secrets[appKey]
+		if !ok {
+			ctx.Fail(http.StatusUnauthorized, "app key is invalid")
+			return
+		}
+
+		ts, err := strconv.ParseInt(timestamp, 10, 64)
+		if err != nil {
+			ctx.Fail(http.StatusUnauthorized, "timestamp is invalid")
+			return
+		}
+		if diff := time.Since(time.Unix(ts, 0)); diff > conf.Expire || diff < -conf.Expire {
+			ctx.Fail(http.StatusUnauthorized, "timestamp is expired")
+			return
+		}
+
+		body, err := ctx.Body()
+		if err != nil {
+			ctx.Fail(http.StatusBadRequest, "read body fail")
+			return
+		}
+		expect := Sign(secret, appKey, ctx.Method, ctx.Path, ctx.Request.URL.Query(), body, timestamp, nonce)
+		if !hmac.Equal([]byte(expect), []byte(strings.ToLower(signature))) {
+			ctx.Fail(http.StatusUnauthorized, "signature is invalid")
+			return
+		}
+
+		// 签名通过后再记录nonce，nonce的有效期覆盖时间戳允许的误差范围
+		client := ctx.Redis()
+		if client == nil {
+			ctx.Log.Error("signature nonce store fail", zap.String("error", "redis is not enabled"))
+			ctx.Fail(http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		key := conf.Prefix + ":" + appKey + ":" + nonce
+		ok, err = client.SetNX(ctx, key, ts, 2*conf.Expire).Result()
+		if err != nil {
+			ctx.Log.Error("signature nonce store fail", zap.Error(err))
+			ctx.Fail(http.StatusInternalServerError, "Internal Server Error")
+			return
+		}
+		if !ok {
+			ctx.Fail(http.StatusUnauthorized, "request is replayed")
+			return
+		}
+		ctx.SetValue(SignAppKey, appKey)
+	}
+}
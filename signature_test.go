@@ -0,0 +1,82 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignature(t *testing.T) {
+	useTestRedis(t)
+	signatureConf.Store(&signatureConfig{
+		Enable:          true,
+		Expire:          time.Minute,
+		Prefix:          "signature_nonce",
+		AppKeyHeader:    "X-App-Key",
+		TimestampHeader: "X-Timestamp",
+		NonceHeader:     "X-Nonce",
+		SignatureHeader: "X-Signature",
+		secrets:         map[string]string{"app": "secret"},
+	})
+	defer signatureConf.Store(&signatureConfig{})
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	expired := strconv.FormatInt(time.Now().Add(-2*time.Minute).Unix(), 10)
+	query := url.Values{"b": {"2", "1"}, "a": {"x y"}}
+	body := `{"id":1}`
+	sign := func(secret, timestamp, nonce string) string {
+		return Sign(secret, "app", http.MethodPost, "/order", query, []byte(body), timestamp, nonce)
+	}
+
+	tests := []struct {
+		name      string
+		appKey    string
+		timestamp string
+		nonce     string
+		signature string
+		body      string
+		status    int
+	}{
+		{"valid", "app", now, "n1", sign("secret", now, "n1"), body, http.StatusOK},
+		{"replayed nonce", "app", now, "n1", sign("secret", now, "n1"), body, http.StatusUnauthorized},
+		{"uppercase signature", "app", now, "n2", strings.ToUpper(sign("secret", now, "n2")), body, http.StatusOK},
+		{"missing params", "app", now, "", sign("secret", now, ""), body, http.StatusUnauthorized},
+		{"unknown app", "other", now, "n3", sign("secret", now, "n3"), body, http.StatusUnauthorized},
+		{"wrong secret", "app", now, "n4", sign("wrong", now, "n4"), body, http.StatusUnauthorized},
+		{"tampered body", "app", now, "n5", sign("secret", now, "n5"), `{"id":2}`, http.StatusUnauthorized},
+		{"expired timestamp", "app", expired, "n6", sign("secret", expired, "n6"), body, http.StatusUnauthorized},
+		{"invalid timestamp", "app", "abc", "n7", sign("secret", "abc", "n7"), body, http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodPost, "/order?"+query.Encode(), strings.NewReader(tt.body))
+		r.Header.Set("X-App-Key", tt.appKey)
+		r.Header.Set("X-Timestamp", tt.timestamp)
+		r.Header.Set("X-Nonce", tt.nonce)
+		r.Header.Set("X-Signature", tt.signature)
+		ctx := newContext(w, r)
+		var appKey string
+		ctx.handlers = []HandlerFunc{Signature(), func(ctx *Context) {
+			appKey = ctx.GetString(SignAppKey)
+			ctx.String(http.StatusOK, "ok")
+		}}
+		ctx.Next()
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d, body %s", tt.name, w.Code, tt.status, w.Body.String())
+		}
+		if tt.status == http.StatusOK && appKey != tt.appKey {
+			t.Errorf("%s: app key = %q, want %q", tt.name, appKey, tt.appKey)
+		}
+	}
+}
+
+func TestCanonicalQuery(t *testing.T) {
+	query := url.Values{"b": {"2", "1"}, "a": {"x y"}, "c": {""}}
+	if got, want := canonicalQuery(query), "a=x+y&b=1&b=2&c="; got != want {
+		t.Fatalf("canonicalQuery = %s, want %s", got, want)
+	}
+}
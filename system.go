@@ -2,11 +2,38 @@ package core
 
 import (
 	"github.com/spf13/viper"
+	"net"
+	"net/http"
+	"strings"
 	"time"
 )
 
 type systemConfig struct {
-	Timeout time.Duration `json:"timeout" mapstructure:"timeout"`
+	Env           string         `json:"env" mapstructure:"env"` //运行环境，dev/test/prod，只有 dev/test 环境才启用故障注入等调试功能
+	Timeout       time.Duration  `json:"timeout" mapstructure:"timeout"`
+	TimeoutStatus int            `json:"timeout_status" mapstructure:"timeout_status"` //超时响应的状态码，默认503
+	RouteTimeouts []routeTimeout `json:"route_timeouts" mapstructure:"route_timeouts"` //指定路由的超时时间
+	MaxGoroutine  int            `json:"max_goroutine" mapstructure:"max_goroutine"`   //异步任务最大并发数，0为不限制
+
+	MaxBodySize        int64           `json:"max_body_size" mapstructure:"max_body_size"`               //请求体最大字节数，0为不限制
+	RouteBodySizes     []routeBodySize `json:"route_body_sizes" mapstructure:"route_body_sizes"`         //指定路由的请求体最大字节数
+	MaxMultipartMemory int64           `json:"max_multipart_memory" mapstructure:"max_multipart_memory"` //解析文件上传时使用的最大内存，超出部分写入临时文件，默认32M
+	ReadTimeout        time.Duration   `json:"read_timeout" mapstructure:"read_timeout"`                 //读取整个请求的超时时间，防止慢速上传占用连接
+	ReadHeaderTimeout  time.Duration   `json:"read_header_timeout" mapstructure:"read_header_timeout"`   //读取请求头的超时时间
+	MaxHeaderBytes     int             `json:"max_header_bytes" mapstructure:"max_header_bytes"`         //请求头最大字节数，默认1M
+	TrustedProxies     []string        `json:"trusted_proxies" mapstructure:"trusted_proxies"`           //可信代理的ip或网段，只有来自可信代理的请求才读取 X-Forwarded-For
+
+	trustedNets []*net.IPNet
+}
+
+type routeTimeout struct {
+	routeRule `mapstructure:",squash"`
+	Timeout   time.Duration `json:"timeout" mapstructure:"timeout"`
+}
+
+type routeBodySize struct {
+	routeRule   `mapstructure:",squash"`
+	MaxBodySize int64 `json:"max_body_size" mapstructure:"max_body_size"`
 }
 
 func initSystemConfig(v *viper.Viper) {
@@ -14,5 +41,63 @@ func initSystemConfig(v *viper.Viper) {
 	if err := v.UnmarshalKey("system", &conf); err != nil {
 		panic(err)
 	}
+	if conf.TimeoutStatus == 0 {
+		conf.TimeoutStatus = http.StatusServiceUnavailable
+	}
+	if conf.MaxMultipartMemory == 0 {
+		conf.MaxMultipartMemory = 32 << 20
+	}
+	if conf.ReadHeaderTimeout == 0 {
+		conf.ReadHeaderTimeout = 10 * time.Second
+	}
+	for _, item := range conf.TrustedProxies {
+		ipNet, err := parseIPNet(item)
+		if err != nil {
+			panic(err)
+		}
+		conf.trustedNets = append(conf.trustedNets, ipNet)
+	}
 	globalSystemConfig = conf
+	initGoroutineLimit(conf.MaxGoroutine)
+}
+
+// isDebugEnv 是否为开发或测试环境，未配置或无法识别的环境按生产环境处理
+func isDebugEnv() bool {
+	switch strings.ToLower(globalSystemConfig.Env) {
+	case "dev", "development", "test":
+		return true
+	}
+	return false
+}
+
+// isTrustedProxy 判断ip是否为可信代理
+func isTrustedProxy(item string) bool {
+	ip := net.ParseIP(item)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range globalSystemConfig.trustedNets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseIPNet 解析ip或网段，单个ip按全掩码处理
+func parseIPNet(item string) (*net.IPNet, error) {
+	item = strings.TrimSpace(item)
+	if strings.Contains(item, "/") {
+		_, ipNet, err := net.ParseCIDR(item)
+		return ipNet, err
+	}
+	ip := net.ParseIP(item)
+	if ip == nil {
+		return nil, &net.ParseError{Type: "IP address", Text: item}
+	}
+	bits := 8 * net.IPv6len
+	if v4 := ip.To4(); v4 != nil {
+		ip, bits = v4, 8*net.IPv4len
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
 }
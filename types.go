@@ -4,6 +4,9 @@ type H map[string]interface{}
 
 var TraceID = "trace-id"
 
+// UserIDKey 当前登录用户ID在上下文中的key
+var UserIDKey = "user_id"
+
 const (
 	GetConfigTip = "get config data"
 	SetConfigTip = "get config data"